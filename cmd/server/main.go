@@ -12,7 +12,9 @@ import (
 	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
 	"github.com/edu-project-ai/docker-pty-proxy/internal/fs"
 	"github.com/edu-project-ai/docker-pty-proxy/internal/handler"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/logs"
 	"github.com/edu-project-ai/docker-pty-proxy/internal/proxy"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/run"
 )
 
 func main() {
@@ -36,8 +38,10 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
-	handler.Register(mux, cli)
-	fs.Register(mux, cli)
+	handler.Register(mux, cli, handler.LoadConfig())
+	fs.Register(mux, cli, fs.LoadConfig())
+	run.Register(mux, cli)
+	logs.Register(mux, cli)
 
 	corsHandler := corsMiddleware(mux)
 
@@ -73,6 +77,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Expose-Headers", "X-File-Binary, X-File-Content-Type")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
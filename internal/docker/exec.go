@@ -0,0 +1,113 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Result is the outcome of a command run with Run.
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Run executes cmd in the container without a TTY, waits for it to finish
+// and returns its demultiplexed output and exit code.
+func Run(ctx context.Context, cli *client.Client, containerID, user string, cmd []string) (*Result, error) {
+	return RunIn(ctx, cli, containerID, user, "", cmd)
+}
+
+// RunIn is Run with cmd started in workDir rather than the container's
+// working directory.
+func RunIn(ctx context.Context, cli *client.Client, containerID, user, workDir string, cmd []string) (*Result, error) {
+	execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		User:         user,
+		WorkingDir:   workDir,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec create: %w", err)
+	}
+
+	hijack, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{
+		Tty: false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec attach: %w", err)
+	}
+	defer hijack.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, hijack.Reader); err != nil {
+		return nil, fmt.Errorf("read exec output: %w", err)
+	}
+
+	code, err := WaitExec(ctx, cli, execResp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		ExitCode: code,
+	}, nil
+}
+
+// WaitExec returns the exit code of an exec whose output stream has ended.
+// The stream closes slightly before Docker records the exit, so the exec
+// is polled briefly until it is reported as finished.
+func WaitExec(ctx context.Context, cli *client.Client, execID string) (int, error) {
+	for i := 0; ; i++ {
+		insp, err := cli.ContainerExecInspect(ctx, execID)
+		if err != nil {
+			return 0, fmt.Errorf("exec inspect: %w", err)
+		}
+		if !insp.Running {
+			return insp.ExitCode, nil
+		}
+		if i == 20 {
+			return 0, fmt.Errorf("exec %s still running after its output closed", execID)
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// killMarkedScript sends a signal to every process whose environment
+// contains the given KEY=VALUE marker, which children inherit.
+const killMarkedScript = `sig=$1 marker=$2 found=1
+for d in /proc/[0-9]*; do
+	tr '\0' '\n' <"$d/environ" 2>/dev/null | grep -qx "$marker" || continue
+	kill -s "$sig" "${d#/proc/}" 2>/dev/null && found=0
+done
+exit $found`
+
+// KillMarked signals (e.g. "KILL") every process in the container started
+// with marker ("KEY=VALUE") in its environment. Docker has no API to stop
+// an exec, so tagging it with a unique variable is how it is found again.
+// user must be the user the processes run as, or root: another user can
+// neither read their environment nor signal them.
+func KillMarked(ctx context.Context, cli *client.Client, containerID, user, marker, sig string) error {
+	res, err := Run(ctx, cli, containerID, user, []string{"/bin/sh", "-c", killMarkedScript, "sh", sig, marker})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("no process found for %s", marker)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Package envconfig reads typed settings from environment variables,
+// falling back to a default (with a warning) when a value is invalid.
+package envconfig
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func String(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// List parses a comma-separated list. An unset variable yields def; a
+// variable set to an empty string yields an empty list.
+func List(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// Int parses a non-negative integer.
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("WARNING: invalid %s=%q, using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using default %s", key, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,137 @@
+// Package eventstream sends JSON events to a browser over server-sent
+// events or a WebSocket, and turns a process's output streams into such
+// events.
+package eventstream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/utf8safe"
+	"github.com/gorilla/websocket"
+)
+
+const writeDeadline = 10 * time.Second
+
+var Upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// Sender delivers one event named typ, whose JSON form is v.
+type Sender interface {
+	Send(typ string, v any) error
+}
+
+// SSE sends events as server-sent events. It is safe for concurrent use.
+type SSE struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+// NewSSE checks that w can stream. Nothing is written until Start.
+func NewSSE(w http.ResponseWriter) (*SSE, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	return &SSE{w: w, flusher: flusher}, nil
+}
+
+// Start sends the response header, after which errors can only be
+// reported as events.
+func (s *SSE) Start() {
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
+
+func (s *SSE) Send(typ string, v any) error {
+	b, _ := json.Marshal(v)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := io.WriteString(s.w, "event: "+typ+"\ndata: "+string(b)+"\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// WS sends events as JSON text frames. It is safe for concurrent use.
+type WS struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func NewWS(ws *websocket.Conn) *WS {
+	return &WS{ws: ws}
+}
+
+func (s *WS) Send(_ string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.ws.SetWriteDeadline(time.Now().Add(writeDeadline))
+	return s.ws.WriteJSON(v)
+}
+
+// Close sends a normal close frame.
+func (s *WS) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
+
+// dataEvent is the event an Output sends for each write.
+type dataEvent struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// Output turns each write to one output stream into a {"type": stream,
+// "data": ...} event. Writes may end in the middle of a multi-byte UTF-8
+// sequence, as Docker's stream frames do, so the incomplete tail is held
+// back until the next write instead of being mangled by JSON encoding.
+// An Output must not be written to concurrently.
+type Output struct {
+	s       Sender
+	stream  string
+	pending []byte
+}
+
+func NewOutput(s Sender, stream string) *Output {
+	return &Output{s: s, stream: stream}
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	data := p
+	if len(o.pending) > 0 {
+		data = slices.Concat(o.pending, p)
+	}
+	data, rest := utf8safe.Split(data)
+	o.pending = append(o.pending[:0], rest...)
+	if len(data) == 0 {
+		return len(p), nil
+	}
+	return len(p), o.s.Send(o.stream, dataEvent{Type: o.stream, Data: string(data)})
+}
+
+// Flush sends any held-back bytes. The stream ended mid-sequence, so they
+// arrive as U+FFFD.
+func (o *Output) Flush() error {
+	if len(o.pending) == 0 {
+		return nil
+	}
+	o.pending = o.pending[:0]
+	return o.s.Send(o.stream, dataEvent{Type: o.stream, Data: string(utf8safe.Replacement)})
+}
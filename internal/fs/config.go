@@ -0,0 +1,21 @@
+package fs
+
+import "github.com/edu-project-ai/docker-pty-proxy/internal/envconfig"
+
+// Config holds the server-side defaults for the file endpoints, read from
+// the environment like the terminal settings.
+type Config struct {
+	// Exclude lists gitignore-style patterns hidden from the tree, directory
+	// listings and search unless a request re-includes them.
+	Exclude []string
+	// GitIgnore honours the workspace's .gitignore files unless a request
+	// overrides it with "gitignore".
+	GitIgnore bool
+}
+
+func LoadConfig() Config {
+	return Config{
+		Exclude:   envconfig.List("FS_EXCLUDE", []string{".*", "node_modules/", "bin/", "obj/", "__pycache__/", "venv/"}),
+		GitIgnore: envconfig.Bool("FS_GITIGNORE", false),
+	}
+}
@@ -0,0 +1,331 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
+)
+
+// Exclusions decides which paths the tree, directory listings and search
+// hide. Patterns use .gitignore syntax and are relative to the workspace.
+type Exclusions struct {
+	Exclude   []string // server defaults followed by the request's own
+	Include   []string // re-include paths an exclusion would hide, like "!pattern"
+	GitIgnore bool     // also apply the workspace's .gitignore files
+}
+
+// exclusions reads the request's exclude, include and gitignore parameters
+// on top of the server defaults.
+func (c Config) exclusions(q url.Values) (Exclusions, error) {
+	ex := Exclusions{
+		Exclude:   append(append([]string(nil), c.Exclude...), q["exclude"]...),
+		Include:   q["include"],
+		GitIgnore: c.GitIgnore,
+	}
+	if v := q.Get("gitignore"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return ex, fmt.Errorf(`invalid "gitignore" parameter %q`, v)
+		}
+		ex.GitIgnore = b
+	}
+	for _, p := range append(q["exclude"], ex.Include...) {
+		if _, _, err := compileRule("", p); err != nil {
+			return ex, err
+		}
+	}
+	return ex, nil
+}
+
+// rule is one compiled .gitignore-style pattern.
+type rule struct {
+	re      *regexp.Regexp
+	dirOnly bool
+	negate  bool
+}
+
+// compileRule compiles a pattern from a .gitignore file in the directory
+// base ("" for the workspace root). ok is false for blank lines and
+// comments.
+func compileRule(base, pattern string) (r rule, ok bool, err error) {
+	pattern = strings.TrimRight(strings.TrimSuffix(pattern, "\r"), " ")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return r, false, nil
+	}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return r, false, nil
+	}
+
+	// A pattern with a slash is anchored to base; one without matches a
+	// name at any depth below it.
+	var expr strings.Builder
+	expr.WriteString("^")
+	if base != "" {
+		expr.WriteString(regexp.QuoteMeta(base) + "/")
+	}
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		expr.WriteString("(?:.*/)?")
+	}
+	expr.WriteString(globToRegexp(pattern))
+	expr.WriteString("$")
+
+	r.re, err = regexp.Compile(expr.String())
+	if err != nil {
+		return r, false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return r, true, nil
+}
+
+// globToRegexp translates a .gitignore glob: "*" and "?" stop at slashes,
+// "**" spans directories and "[...]" is a character class.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// matcher applies rules in order; as in git, the last matching rule wins.
+type matcher struct {
+	rules []rule
+}
+
+func (m *matcher) add(base string, patterns []string, negate bool) error {
+	for _, p := range patterns {
+		r, ok, err := compileRule(base, p)
+		if err != nil {
+			return err
+		}
+		if ok {
+			r.negate = r.negate != negate
+			m.rules = append(m.rules, r)
+		}
+	}
+	return nil
+}
+
+// excluded reports whether the workspace-relative path p is hidden. It
+// looks at p alone; callers skip the contents of excluded folders.
+func (m *matcher) excluded(p string, isDir bool) bool {
+	hidden := false
+	for _, r := range m.rules {
+		if (!r.dirOnly || isDir) && r.re.MatchString(p) {
+			hidden = !r.negate
+		}
+	}
+	return hidden
+}
+
+// excludedFile reports whether the file p or any folder above it is hidden.
+func (m *matcher) excludedFile(p string) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if m.excluded(dir, true) {
+			return true
+		}
+	}
+	return m.excluded(p, false)
+}
+
+// matcher compiles ex for containerID, reading every .gitignore in the
+// workspace if ex asks for them.
+func (s *Service) matcher(ctx context.Context, containerID string, ex Exclusions) (*matcher, error) {
+	var files []gitignoreFile
+	if ex.GitIgnore {
+		var err error
+		if files, err = s.readGitignores(ctx, containerID, ex); err != nil {
+			return nil, err
+		}
+	}
+	return newMatcher(ex, files)
+}
+
+// newMatcher compiles ex with the given .gitignore files, which apply
+// only if ex.GitIgnore is set.
+func newMatcher(ex Exclusions, files []gitignoreFile) (*matcher, error) {
+	m := &matcher{}
+	if err := m.add("", ex.Exclude, false); err != nil {
+		return nil, err
+	}
+	if ex.GitIgnore {
+		for _, f := range files {
+			if err := m.add(f.base, f.lines, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := m.add("", ex.Include, true); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// gitignoreScript prints each .gitignore file given as an argument, after
+// a "==> path" header line. Missing files are skipped.
+const gitignoreScript = `for f; do [ -f "$f" ] || continue; printf '==> %s\n' "$f"; cat "$f"; echo; done`
+
+type gitignoreFile struct {
+	base  string // folder the file is in, "" for the workspace root
+	lines []string
+}
+
+// readGitignores returns every .gitignore file in the workspace.
+func (s *Service) readGitignores(ctx context.Context, containerID string, ex Exclusions) ([]gitignoreFile, error) {
+	cmd := append([]string{"find", workspace.Root, "-mindepth", "1"}, ex.pruneArgs(true)...)
+	cmd = append(cmd, "-name", ".gitignore", "-type", "f", "-exec", "sh", "-c", gitignoreScript, "sh", "{}", "+")
+
+	res, err := docker.Run(ctx, s.cli, containerID, "", cmd)
+	if err != nil {
+		return nil, fmt.Errorf("read .gitignore: %w", err)
+	}
+	return parseGitignores(string(res.Stdout)), nil
+}
+
+// gitignorePaths returns the absolute paths of the .gitignore files that
+// apply inside dirs, for gitignoreScript.
+func gitignorePaths(dirs []string) []string {
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		paths[i] = path.Join(workspace.Root, dir, ".gitignore")
+	}
+	return paths
+}
+
+// parseGitignores reads the output of gitignoreScript, outermost file
+// first so that nested files take precedence.
+func parseGitignores(output string) []gitignoreFile {
+	var files []gitignoreFile
+	for _, line := range strings.Split(output, "\n") {
+		if name, ok := strings.CutPrefix(line, "==> "); ok {
+			base := strings.TrimPrefix(path.Dir(name), workspace.Root)
+			files = append(files, gitignoreFile{base: strings.TrimPrefix(base, "/")})
+			continue
+		}
+		if len(files) > 0 {
+			f := &files[len(files)-1]
+			f.lines = append(f.lines, line)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return depth(files[i].base) < depth(files[j].base)
+	})
+	return files
+}
+
+func depth(dir string) int {
+	if dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// ancestors returns the workspace root and every folder down to dir.
+func ancestors(dir string) []string {
+	dirs := []string{""}
+	if dir == "." {
+		return dirs
+	}
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+	}
+	return dirs
+}
+
+// pruneArgs returns find arguments that skip what the simple name patterns
+// of ex exclude, so that find does not walk into large excluded folders;
+// the matcher filters everything else afterwards. A pattern is left to the
+// matcher if an include might re-include something under it. With
+// dirsOnly, files are never pruned.
+func (ex Exclusions) pruneArgs(dirsOnly bool) []string {
+	var terms [][]string
+	for _, p := range ex.Exclude {
+		dirOnly := strings.HasSuffix(p, "/")
+		name := strings.TrimRight(p, "/")
+		if name == "" || strings.ContainsAny(name, `/\!#`) || strings.Contains(name, "**") || !prunable(name, ex.Include) {
+			continue
+		}
+		if dirOnly || dirsOnly {
+			terms = append(terms, []string{"(", "-type", "d", "-name", name, ")"})
+		} else {
+			terms = append(terms, []string{"-name", name})
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	args := []string{"("}
+	for i, t := range terms {
+		if i > 0 {
+			args = append(args, "-o")
+		}
+		args = append(args, t...)
+	}
+	return append(args, ")", "-prune", "-o")
+}
+
+// prunable reports whether no include pattern can match inside a folder
+// named name.
+func prunable(name string, includes []string) bool {
+	for _, inc := range includes {
+		for _, seg := range strings.Split(strings.Trim(strings.TrimPrefix(inc, "!"), "/"), "/") {
+			if strings.ContainsAny(seg, `*?[\`) {
+				return false
+			}
+			if ok, _ := path.Match(name, seg); ok {
+				return false
+			}
+		}
+	}
+	return true
+}
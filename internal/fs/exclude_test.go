@@ -0,0 +1,209 @@
+package fs
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestCompileRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// Unanchored patterns match a name at any depth.
+		{"name at root", "", "*.log", "app.log", false, true},
+		{"name nested", "", "*.log", "a/b/app.log", false, true},
+		{"star stops at slash", "", "*.log", "a/b", false, false},
+		{"question mark", "", "?.txt", "a.txt", false, true},
+		{"question mark not slash", "", "a?b", "a/b", false, false},
+		{"class", "", "file[0-9]", "file7", false, true},
+		{"negated class", "", "file[!0-9]", "file7", false, false},
+
+		// A slash anywhere but the end anchors the pattern.
+		{"leading slash anchors", "", "/dist", "dist", true, true},
+		{"leading slash not nested", "", "/dist", "pkg/dist", true, false},
+		{"inner slash anchors", "", "src/gen", "src/gen", true, true},
+		{"inner slash not nested", "", "src/gen", "x/src/gen", true, false},
+
+		// Patterns from a nested .gitignore are relative to its folder.
+		{"base unanchored", "web", "*.map", "web/js/app.map", false, true},
+		{"base outside", "web", "*.map", "api/app.map", false, false},
+		{"base anchored", "web", "/build", "web/build", true, true},
+		{"base anchored nested", "web", "/build", "web/x/build", true, false},
+
+		// "**" spans folders.
+		{"leading double star", "", "**/tmp", "a/b/tmp", true, true},
+		{"leading double star at root", "", "**/tmp", "tmp", true, true},
+		{"trailing double star", "", "logs/**", "logs/a/b.txt", false, true},
+		{"trailing double star not folder", "", "logs/**", "logs", true, false},
+		{"middle double star", "", "a/**/b", "a/x/y/b", false, true},
+		{"middle double star direct", "", "a/**/b", "a/b", false, true},
+
+		// A trailing slash only matches folders.
+		{"dir only on folder", "", "build/", "build", true, true},
+		{"dir only on file", "", "build/", "build", false, false},
+		{"dir only nested", "", "build/", "a/build", true, true},
+
+		// Escapes.
+		{"escaped hash", "", `\#notes`, "#notes", false, true},
+		{"escaped bang", "", `\!important`, "!important", false, true},
+		{"escaped star", "", `a\*`, "a*", false, true},
+		{"escaped star literal", "", `a\*`, "ab", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok, err := compileRule(tt.base, tt.pattern)
+			if err != nil || !ok {
+				t.Fatalf("compileRule(%q, %q) = ok %v, err %v", tt.base, tt.pattern, ok, err)
+			}
+			got := (!r.dirOnly || tt.isDir) && r.re.MatchString(tt.path)
+			if got != tt.want {
+				t.Errorf("%q (base %q) matching %q (dir %v) = %v, want %v (regexp %s)",
+					tt.pattern, tt.base, tt.path, tt.isDir, got, tt.want, r.re)
+			}
+		})
+	}
+}
+
+func TestCompileRuleSkips(t *testing.T) {
+	for _, pattern := range []string{"", "   ", "# comment", "/", "\r"} {
+		if _, ok, err := compileRule("", pattern); ok || err != nil {
+			t.Errorf("compileRule(%q) = ok %v, err %v; want skipped", pattern, ok, err)
+		}
+	}
+	r, ok, _ := compileRule("", "!keep.log")
+	if !ok || !r.negate {
+		t.Errorf(`compileRule("!keep.log") negate = %v, want true`, r.negate)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	m, err := newMatcher(Exclusions{
+		Exclude:   []string{".*", "node_modules/", "*.log", "/dist"},
+		Include:   []string{".env"},
+		GitIgnore: true,
+	}, []gitignoreFile{
+		{base: "", lines: []string{"# generated", "coverage/", ""}},
+		{base: "src", lines: []string{"gen/", "!keep.log"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{".git", true, true},
+		{".env", false, false},   // re-included
+		{"a/.env", false, false}, // re-included at any depth
+		{"node_modules", true, true},
+		{"node_modules", false, false}, // dir-only rule
+		{"debug.log", false, true},
+		{"src/keep.log", false, false}, // negated by the nested .gitignore
+		{"lib/keep.log", false, true},  // negation only applies under src
+		{"dist", true, true},
+		{"pkg/dist", true, false},
+		{"coverage", true, true},
+		{"src/gen", true, true},
+		{"gen", true, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.excluded(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("excluded(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	if !m.excludedFile("node_modules/react/index.js") {
+		t.Error("file inside an excluded folder is not excluded")
+	}
+	if m.excludedFile("src/app/main.go") {
+		t.Error("src/app/main.go is excluded")
+	}
+}
+
+func TestMatcherIgnoresGitignoreWhenDisabled(t *testing.T) {
+	m, err := newMatcher(Exclusions{}, []gitignoreFile{{lines: []string{"*.go"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.excluded("main.go", false) {
+		t.Error(".gitignore applied although GitIgnore is off")
+	}
+}
+
+func TestParseGitignores(t *testing.T) {
+	out := "==> /workspace/src/.gitignore\ngen/\n\n==> /workspace/.gitignore\n*.log\n\n"
+	files := parseGitignores(out)
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2", len(files))
+	}
+	if files[0].base != "" || !slices.Contains(files[0].lines, "*.log") {
+		t.Errorf("first file = %+v, want the root .gitignore", files[0])
+	}
+	if files[1].base != "src" || !slices.Contains(files[1].lines, "gen/") {
+		t.Errorf("second file = %+v, want src/.gitignore", files[1])
+	}
+}
+
+func TestPruneArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		ex       Exclusions
+		dirsOnly bool
+		want     []string
+	}{
+		{"none", Exclusions{}, false, nil},
+		{
+			"names and folders", Exclusions{Exclude: []string{".*", "bin/"}}, false,
+			[]string{"(", "-name", ".*", "-o", "(", "-type", "d", "-name", "bin", ")", ")", "-prune", "-o"},
+		},
+		{
+			"dirs only", Exclusions{Exclude: []string{".*"}}, true,
+			[]string{"(", "(", "-type", "d", "-name", ".*", ")", ")", "-prune", "-o"},
+		},
+		{
+			"paths and double stars are left to the matcher", Exclusions{Exclude: []string{"/dist", "src/gen", "**/tmp", "!x"}}, false,
+			nil,
+		},
+		{
+			"include under the folder keeps it", Exclusions{Exclude: []string{"node_modules/", "bin/"}, Include: []string{"node_modules/react"}}, false,
+			[]string{"(", "(", "-type", "d", "-name", "bin", ")", ")", "-prune", "-o"},
+		},
+		{
+			"wildcard include keeps everything", Exclusions{Exclude: []string{"bin/"}, Include: []string{"*.txt"}}, false,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ex.pruneArgs(tt.dirsOnly); !slices.Equal(got, tt.want) {
+				t.Errorf("pruneArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	cfg := Config{Exclude: []string{".*"}, GitIgnore: true}
+	ex, err := cfg.exclusions(url.Values{"exclude": {"*.log"}, "include": {".env"}, "gitignore": {"false"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ex.Exclude, []string{".*", "*.log"}) || !slices.Equal(ex.Include, []string{".env"}) || ex.GitIgnore {
+		t.Errorf("exclusions = %+v", ex)
+	}
+	if !slices.Equal(cfg.Exclude, []string{".*"}) {
+		t.Errorf("request patterns leaked into the config: %q", cfg.Exclude)
+	}
+	if _, err := cfg.exclusions(url.Values{"gitignore": {"maybe"}}); err == nil {
+		t.Error("invalid gitignore parameter accepted")
+	}
+}
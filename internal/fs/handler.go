@@ -1,20 +1,31 @@
 package fs
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/docker/docker/client"
 )
 
-func Register(mux *http.ServeMux, cli *client.Client) {
-	svc := New(cli)
+func Register(mux *http.ServeMux, cli *client.Client, cfg Config) {
+	svc := New(cli, cfg)
 	mux.HandleFunc("/fs/tree", treeHandler(svc))
+	mux.HandleFunc("/fs/list", listHandler(svc))
+	mux.HandleFunc("/fs/stat", statHandler(svc))
 	mux.HandleFunc("/fs/file", fileHandler(svc))
 	mux.HandleFunc("/fs/search", searchHandler(svc))
+	mux.HandleFunc("/fs/create", createHandler(svc, false))
+	mux.HandleFunc("/fs/mkdir", createHandler(svc, true))
+	mux.HandleFunc("/fs/delete", deleteHandler(svc))
+	mux.HandleFunc("/fs/move", transferHandler(svc, "move"))
+	mux.HandleFunc("/fs/copy", transferHandler(svc, "copy"))
 }
 
 func treeHandler(svc *Service) http.HandlerFunc {
@@ -30,7 +41,13 @@ func treeHandler(svc *Service) http.HandlerFunc {
 			return
 		}
 
-		tree, err := svc.GetFileTree(r.Context(), containerID)
+		ex, err := svc.cfg.exclusions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tree, err := svc.GetFileTree(r.Context(), containerID, ex)
 		if err != nil {
 			log.Printf("[fs/tree] error for container %s: %v", containerID, err)
 			http.Error(w, "failed to get file tree", http.StatusInternalServerError)
@@ -44,6 +61,127 @@ func treeHandler(svc *Service) http.HandlerFunc {
 	}
 }
 
+// listHandler returns one page of a directory's children:
+// GET /fs/list?id={container}&path=src[&cursor=...&limit=500&exclude=...&include=...&gitignore=true]
+func listHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		containerID := r.URL.Query().Get("id")
+		if containerID == "" {
+			http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+			return
+		}
+
+		dirPath := r.URL.Query().Get("path")
+		if dirPath != "" {
+			if err := validatePath(dirPath); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit := defaultListLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxListLimit {
+				http.Error(w, fmt.Sprintf(`invalid "limit" parameter, must be between 1 and %d`, maxListLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		ex, err := svc.cfg.exclusions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		listing, err := svc.ListDir(r.Context(), containerID, dirPath, r.URL.Query().Get("cursor"), limit, ex)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrNotFound):
+				http.Error(w, "directory not found", http.StatusNotFound)
+			case errors.Is(err, ErrNotDirectory), errors.Is(err, ErrInvalidCursor):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				log.Printf("[fs/list] error for %s in %s: %v", dirPath, containerID, err)
+				http.Error(w, "failed to list directory", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(listing); err != nil {
+			log.Printf("[fs/list] encode error: %v", err)
+		}
+	}
+}
+
+// statHandler describes a single file or folder:
+// GET /fs/stat?id={container}&path=src/main.py
+func statHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		containerID := r.URL.Query().Get("id")
+		if containerID == "" {
+			http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+			return
+		}
+
+		filePath := r.URL.Query().Get("path")
+		if err := validatePath(filePath); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		node, err := svc.StatFile(r.Context(), containerID, filePath)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.Error(w, "file not found", http.StatusNotFound)
+			} else {
+				log.Printf("[fs/stat] error for %s in %s: %v", filePath, containerID, err)
+				http.Error(w, "failed to stat file", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(node); err != nil {
+			log.Printf("[fs/stat] encode error: %v", err)
+		}
+	}
+}
+
+// fileEnvelope carries file contents in JSON, base64-encoded so that binary
+// files survive. Requests only need Content.
+type fileEnvelope struct {
+	Path        string `json:"path,omitempty"`
+	Size        int    `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+	Binary      bool   `json:"binary"`
+	Encoding    string `json:"encoding"` // always "base64"
+	Content     string `json:"content"`
+}
+
+// tooLargeResponse is the JSON body of a read refused for size, with
+// enough to show a placeholder for the file.
+type tooLargeResponse struct {
+	apiError
+	Size   int64 `json:"size"`
+	Binary bool  `json:"binary"`
+}
+
+// fileHandler reads (GET) or writes (POST) a file's raw bytes:
+// /fs/file?id={container}&path=img/logo.png. With encoding=base64 both
+// directions use a fileEnvelope instead.
 func fileHandler(svc *Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		containerID := r.URL.Query().Get("id")
@@ -58,28 +196,96 @@ func fileHandler(svc *Service) http.HandlerFunc {
 			return
 		}
 
+		var envelope bool
+		switch enc := r.URL.Query().Get("encoding"); enc {
+		case "", "raw":
+		case "base64":
+			envelope = true
+		default:
+			http.Error(w, fmt.Sprintf(`invalid "encoding" parameter %q, must be "raw" or "base64"`, enc), http.StatusBadRequest)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
 			content, err := svc.ReadFile(r.Context(), containerID, filePath)
 			if err != nil {
 				log.Printf("[fs/file] read error for %s in %s: %v", filePath, containerID, err)
-				if strings.Contains(err.Error(), "No such container") || strings.Contains(err.Error(), "not found") {
+				var tooLarge *TooLargeError
+				if errors.As(err, &tooLarge) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					_ = json.NewEncoder(w).Encode(tooLargeResponse{
+						apiError: apiError{Error: err.Error(), Code: "too_large"},
+						Size:     tooLarge.Size,
+						Binary:   tooLarge.Binary,
+					})
+				} else if strings.Contains(err.Error(), "No such container") || strings.Contains(err.Error(), "not found") {
 					http.Error(w, "file not found", http.StatusNotFound)
 				} else {
 					http.Error(w, "failed to read file", http.StatusInternalServerError)
 				}
 				return
 			}
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			_, _ = io.WriteString(w, content)
+
+			contentType := http.DetectContentType(content)
+			binary := isBinary(content)
+			if envelope {
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(fileEnvelope{
+					Path:        filePath,
+					Size:        len(content),
+					ContentType: contentType,
+					Binary:      binary,
+					Encoding:    "base64",
+					Content:     base64.StdEncoding.EncodeToString(content),
+				}); err != nil {
+					log.Printf("[fs/file] encode error: %v", err)
+				}
+				return
+			}
+			// Workspace files are untrusted, so the response is never served
+			// as a type the browser would run (HTML, SVG, XML): it could
+			// script this origin if opened in a tab. The sniffed type is
+			// reported in X-File-Content-Type instead.
+			w.Header().Set("Content-Type", servedContentType(contentType))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("Content-Security-Policy", "sandbox")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("X-File-Content-Type", contentType)
+			w.Header().Set("X-File-Binary", strconv.FormatBool(binary))
+			_, _ = w.Write(content)
 
 		case http.MethodPost:
-			body, err := io.ReadAll(io.LimitReader(r.Body, maxFileSize))
+			limit := int64(maxFileSize)
+			if envelope {
+				limit = int64(base64.StdEncoding.EncodedLen(maxFileSize)) + 64*1024
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
 			if err != nil {
 				http.Error(w, "failed to read request body", http.StatusBadRequest)
 				return
 			}
-			if err := svc.WriteFile(r.Context(), containerID, filePath, string(body)); err != nil {
+			if int64(len(body)) > limit {
+				http.Error(w, ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if envelope {
+				var env fileEnvelope
+				if err := json.Unmarshal(body, &env); err != nil {
+					http.Error(w, "invalid JSON body", http.StatusBadRequest)
+					return
+				}
+				if body, err = base64.StdEncoding.DecodeString(env.Content); err != nil {
+					http.Error(w, `invalid base64 in "content"`, http.StatusBadRequest)
+					return
+				}
+				if len(body) > maxFileSize {
+					http.Error(w, ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
+			if err := svc.WriteFile(r.Context(), containerID, filePath, body); err != nil {
 				log.Printf("[fs/file] write error for %s in %s: %v", filePath, containerID, err)
 				http.Error(w, "failed to write file", http.StatusInternalServerError)
 				return
@@ -114,7 +320,13 @@ func searchHandler(svc *Service) http.HandlerFunc {
 		matchCase := r.URL.Query().Get("matchCase") == "true"
 		matchWord := r.URL.Query().Get("matchWord") == "true"
 
-		results, err := svc.SearchFiles(r.Context(), containerID, query, matchCase, matchWord)
+		ex, err := svc.cfg.exclusions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := svc.SearchFiles(r.Context(), containerID, query, matchCase, matchWord, ex)
 		if err != nil {
 			log.Printf("[fs/search] error for container %s: %v", containerID, err)
 			http.Error(w, "failed to search files", http.StatusInternalServerError)
@@ -127,3 +339,154 @@ func searchHandler(svc *Service) http.HandlerFunc {
 		}
 	}
 }
+
+// apiError is the JSON body of a failed file operation.
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"` // "bad_request", "invalid_path", "not_found", "exists", "not_empty", "not_directory", "too_large" or "internal"
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Error: msg, Code: code})
+}
+
+// opError answers a failed file operation with the matching status and
+// error code.
+func opError(w http.ResponseWriter, tag, containerID string, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, ErrExists):
+		writeError(w, http.StatusConflict, "exists", err.Error())
+	case errors.Is(err, ErrNotEmpty):
+		writeError(w, http.StatusConflict, "not_empty", err.Error())
+	case errors.Is(err, ErrNotDirectory):
+		writeError(w, http.StatusConflict, "not_directory", err.Error())
+	case errors.Is(err, ErrIntoItself), errors.Is(err, ErrWorkspaceRoot):
+		writeError(w, http.StatusBadRequest, "invalid_path", err.Error())
+	default:
+		log.Printf("[%s] error for container %s: %v", tag, containerID, err)
+		writeError(w, http.StatusInternalServerError, "internal", "file operation failed")
+	}
+}
+
+// opRequest checks the method and container of a file operation and
+// validates the path parameters it names.
+func opRequest(w http.ResponseWriter, r *http.Request, params ...string) (string, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "bad_request", "method not allowed")
+		return "", false
+	}
+	containerID := r.URL.Query().Get("id")
+	if containerID == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", `missing "id" query parameter`)
+		return "", false
+	}
+	for _, p := range params {
+		if _, err := targetPath(r.URL.Query().Get(p)); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_path", fmt.Sprintf("%q: %v", p, err))
+			return "", false
+		}
+	}
+	return containerID, true
+}
+
+func writeNode(w http.ResponseWriter, status int, tag string, node *FileNode) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(node); err != nil {
+		log.Printf("[%s] encode error: %v", tag, err)
+	}
+}
+
+// createHandler creates an empty file, or a folder for /fs/mkdir, with any
+// missing parents: POST /fs/create?id={container}&path=src/new.ts
+func createHandler(svc *Service, dir bool) http.HandlerFunc {
+	tag := "fs/create"
+	if dir {
+		tag = "fs/mkdir"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, ok := opRequest(w, r, "path")
+		if !ok {
+			return
+		}
+		node, err := svc.Create(r.Context(), containerID, r.URL.Query().Get("path"), dir)
+		if err != nil {
+			opError(w, tag, containerID, err)
+			return
+		}
+		writeNode(w, http.StatusCreated, tag, node)
+	}
+}
+
+// deleteHandler removes a file or folder; a folder that is not empty needs
+// recursive=true: POST /fs/delete?id={container}&path=build[&recursive=true]
+func deleteHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, ok := opRequest(w, r, "path")
+		if !ok {
+			return
+		}
+		recursive := r.URL.Query().Get("recursive") == "true"
+		if err := svc.Delete(r.Context(), containerID, r.URL.Query().Get("path"), recursive); err != nil {
+			opError(w, "fs/delete", containerID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// transferHandler moves or copies a file or folder:
+// POST /fs/move?id={container}&from=a.txt&to=src/b.txt[&overwrite=true]
+// For /fs/copy, "to" may be omitted to duplicate next to the original.
+func transferHandler(svc *Service, op string) http.HandlerFunc {
+	tag := "fs/" + op
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := []string{"from", "to"}
+		if op == "copy" && r.URL.Query().Get("to") == "" {
+			params = params[:1]
+		}
+		containerID, ok := opRequest(w, r, params...)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		overwrite := q.Get("overwrite") == "true"
+		var node *FileNode
+		var err error
+		status := http.StatusCreated
+		if op == "move" {
+			node, err = svc.Move(r.Context(), containerID, q.Get("from"), q.Get("to"), overwrite)
+			status = http.StatusOK
+		} else {
+			node, err = svc.Copy(r.Context(), containerID, q.Get("from"), q.Get("to"), overwrite)
+		}
+		if err != nil {
+			opError(w, tag, containerID, err)
+			return
+		}
+		writeNode(w, status, tag, node)
+	}
+}
+
+// servedContentType returns the Content-Type a raw read is sent with for
+// a file sniffed as contentType. Images, audio, video and fonts keep their
+// type so they can be previewed directly; other text is sent as plain
+// text and everything else as opaque bytes.
+func servedContentType(contentType string) string {
+	switch media, _, _ := strings.Cut(contentType, ";"); {
+	case media == "image/svg+xml":
+		return "text/plain; charset=utf-8"
+	case strings.HasPrefix(media, "image/"), strings.HasPrefix(media, "audio/"),
+		strings.HasPrefix(media, "video/"), strings.HasPrefix(media, "font/"):
+		return contentType
+	case strings.HasPrefix(media, "text/"):
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,181 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
+)
+
+var (
+	ErrExists        = errors.New("file exists")
+	ErrNotEmpty      = errors.New("directory not empty")
+	ErrIntoItself    = errors.New("cannot move or copy a path onto or into itself")
+	ErrWorkspaceRoot = errors.New("cannot modify the workspace root")
+)
+
+// The scripts below take absolute paths and report the common failures
+// through their exit status, which runOp turns into errors.
+const (
+	exitNotFound     = 2
+	exitNotDirectory = 3
+	exitExists       = 4
+	exitNotEmpty     = 5
+	exitIntoItself   = 6
+)
+
+// parentScript creates the missing parent folders of $dst, failing with
+// exitNotDirectory if the parent is a file.
+const parentScript = `d=$(dirname "$dst"); [ -e "$d" ] && [ ! -d "$d" ] && exit 3; mkdir -p "$d" || exit 1`
+
+// createScript creates the empty file $1, or the folder $1 if $2 is "d".
+const createScript = `{ [ -e "$1" ] || [ -L "$1" ]; } && exit 4
+dst=$1; ` + parentScript + `
+if [ "$2" = d ]; then mkdir "$1" || exit 1; else set -C; : > "$1" || exit 1; fi`
+
+// deleteScript removes $1. A folder that is not empty is only removed if
+// $2 is "1".
+const deleteScript = `[ -e "$1" ] || [ -L "$1" ] || exit 2
+if [ -d "$1" ] && [ ! -L "$1" ]; then
+  [ "$2" = 1 ] && { rm -rf "$1"; exit; }
+  [ -n "$(ls -A "$1")" ] && exit 5
+  rmdir "$1"
+else
+  rm -f "$1"
+fi`
+
+// transferScript moves ($1 = "mv") or copies ($1 = "cp") $2 to $3 and
+// prints the destination. An existing destination is replaced only if $4
+// is "1", and a folder only if it is empty. Copying with an empty $3
+// duplicates $2 next to itself as "name copy.ext", "name copy 2.ext", ...
+const transferScript = `[ -e "$2" ] || [ -L "$2" ] || exit 2
+if [ "$1" = cp ] && [ -z "$3" ]; then
+  d=$(dirname "$2"); b=$(basename "$2"); stem=${b%.*}; ext=${b#"$stem"}
+  if [ -z "$stem" ] || [ -d "$2" ]; then stem=$b; ext=; fi
+  t="$d/$stem copy$ext"; n=2
+  while [ -e "$t" ] || [ -L "$t" ]; do t="$d/$stem copy $n$ext"; n=$((n+1)); done
+  set -- "$1" "$2" "$t" "$4"
+fi
+case "$3/" in "$2"/*) exit 6;; esac
+if [ -e "$3" ] || [ -L "$3" ]; then
+  [ "$4" = 1 ] || exit 4
+  if [ -d "$3" ] && [ ! -L "$3" ]; then
+    [ -n "$(ls -A "$3")" ] && exit 5
+    rmdir "$3" || exit 1
+  else
+    rm -f "$3" || exit 1
+  fi
+fi
+dst=$3; ` + parentScript + `
+if [ "$1" = cp ]; then cp -a "$2" "$3"; else mv "$2" "$3"; fi || exit 1
+printf '%s\n' "$3"`
+
+// targetPath validates p for an operation that changes it and returns it
+// cleaned. The workspace root itself cannot be changed.
+func targetPath(p string) (string, error) {
+	if err := validatePath(p); err != nil {
+		return "", err
+	}
+	p = path.Clean(p)
+	if p == "." {
+		return "", ErrWorkspaceRoot
+	}
+	return p, nil
+}
+
+// runOp runs one of the scripts above and returns its output.
+func (s *Service) runOp(ctx context.Context, containerID, op, script string, args ...string) (string, error) {
+	res, err := docker.Run(ctx, s.cli, containerID, "", append([]string{"sh", "-c", script, "sh"}, args...))
+	if err != nil {
+		return "", err
+	}
+	switch res.ExitCode {
+	case 0:
+		return string(res.Stdout), nil
+	case exitNotFound:
+		return "", ErrNotFound
+	case exitNotDirectory:
+		return "", ErrNotDirectory
+	case exitExists:
+		return "", ErrExists
+	case exitNotEmpty:
+		return "", ErrNotEmpty
+	case exitIntoItself:
+		return "", ErrIntoItself
+	default:
+		return "", fmt.Errorf("%s failed (exit %d): %s", op, res.ExitCode, strings.TrimSpace(string(res.Stderr)))
+	}
+}
+
+// Create creates an empty file, or a folder if dir is set, along with any
+// missing parent folders, and returns the new entry.
+func (s *Service) Create(ctx context.Context, containerID, filePath string, dir bool) (*FileNode, error) {
+	filePath, err := targetPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	kind := "f"
+	if dir {
+		kind = "d"
+	}
+	if _, err := s.runOp(ctx, containerID, "create "+filePath, createScript, path.Join(workspace.Root, filePath), kind); err != nil {
+		return nil, err
+	}
+	return s.StatFile(ctx, containerID, filePath)
+}
+
+// Delete removes a file, symlink or folder. A folder that is not empty
+// fails with ErrNotEmpty unless recursive is set.
+func (s *Service) Delete(ctx context.Context, containerID, filePath string, recursive bool) error {
+	filePath, err := targetPath(filePath)
+	if err != nil {
+		return err
+	}
+	flag := ""
+	if recursive {
+		flag = "1"
+	}
+	_, err = s.runOp(ctx, containerID, "delete "+filePath, deleteScript, path.Join(workspace.Root, filePath), flag)
+	return err
+}
+
+// Move renames or moves from to to and returns the entry at its new path.
+func (s *Service) Move(ctx context.Context, containerID, from, to string, overwrite bool) (*FileNode, error) {
+	return s.transfer(ctx, containerID, "mv", from, to, overwrite)
+}
+
+// Copy copies from, recursively for folders, to to and returns the new
+// entry. An empty to duplicates from next to itself under a free
+// "name copy" name.
+func (s *Service) Copy(ctx context.Context, containerID, from, to string, overwrite bool) (*FileNode, error) {
+	return s.transfer(ctx, containerID, "cp", from, to, overwrite)
+}
+
+func (s *Service) transfer(ctx context.Context, containerID, op, from, to string, overwrite bool) (*FileNode, error) {
+	from, err := targetPath(from)
+	if err != nil {
+		return nil, err
+	}
+	dest := ""
+	if to != "" || op != "cp" {
+		if to, err = targetPath(to); err != nil {
+			return nil, err
+		}
+		dest = path.Join(workspace.Root, to)
+	}
+	flag := ""
+	if overwrite {
+		flag = "1"
+	}
+
+	out, err := s.runOp(ctx, containerID, op+" "+from, transferScript, op, path.Join(workspace.Root, from), dest, flag)
+	if err != nil {
+		return nil, err
+	}
+	to = strings.TrimPrefix(strings.TrimSuffix(out, "\n"), workspace.Root+"/")
+	return s.StatFile(ctx, containerID, to)
+}
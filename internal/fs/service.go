@@ -4,6 +4,8 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,16 +16,59 @@ import (
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
 )
 
-const maxFileSize = 5 * 1024 * 1024 // 5 MB
+const (
+	maxFileSize = 5 * 1024 * 1024 // 5 MB
+
+	defaultListLimit = 500
+	maxListLimit     = 5000
+)
+
+var (
+	ErrNotFound      = errors.New("no such file or directory")
+	ErrNotDirectory  = errors.New("not a directory")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrTooLarge      = fmt.Errorf("file is larger than %d bytes", maxFileSize)
+)
+
+// TooLargeError is returned by ReadFile for a file over maxFileSize, so
+// that callers can still describe it. It matches ErrTooLarge.
+type TooLargeError struct {
+	Size   int64
+	Binary bool
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("file is %d bytes, larger than the %d byte limit", e.Size, maxFileSize)
+}
+
+func (e *TooLargeError) Is(target error) bool { return target == ErrTooLarge }
 
 type FileNode struct {
-	Name     string      `json:"name"`
-	Path     string      `json:"path"`
-	Type     string      `json:"type"` // "file" or "folder"
-	Children []*FileNode `json:"children,omitempty"`
+	Name       string      `json:"name"`
+	Path       string      `json:"path"`
+	Type       string      `json:"type"`           // "file" or "folder"; a symlink has the type of its target
+	Size       int64       `json:"size,omitempty"` // bytes, files only
+	Mode       string      `json:"mode,omitempty"` // permission bits in octal, e.g. "0644"
+	ModTime    time.Time   `json:"mtime"`
+	Owner      string      `json:"owner,omitempty"`
+	Symlink    bool        `json:"symlink,omitempty"`
+	Target     string      `json:"target,omitempty"` // symlink target, as stored in the link
+	Executable bool        `json:"executable,omitempty"`
+	Binary     bool        `json:"binary,omitempty"` // hint that the file should not be opened as text
+	Children   []*FileNode `json:"children,omitempty"`
+}
+
+// DirListing is one page of a directory's immediate children, folders
+// first and then by name.
+type DirListing struct {
+	Path       string      `json:"path"`
+	Entries    []*FileNode `json:"entries"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"nextCursor,omitempty"`
 }
 
 type SearchResult struct {
@@ -35,10 +80,11 @@ type SearchResult struct {
 
 type Service struct {
 	cli *client.Client
+	cfg Config
 }
 
-func New(cli *client.Client) *Service {
-	return &Service{cli: cli}
+func New(cli *client.Client, cfg Config) *Service {
+	return &Service{cli: cli, cfg: cfg}
 }
 
 func validatePath(p string) error {
@@ -58,99 +104,57 @@ func validatePath(p string) error {
 	return nil
 }
 
-func (s *Service) GetFileTree(ctx context.Context, containerID string) ([]*FileNode, error) {
-	// FIX: Alpine Linux (BusyBox) не підтримує -printf.
-	// Використовуємо -exec stat, щоб отримати шлях і тип файлу.
-	// %n = ім'я файлу, %F = тип (regular file / directory)
-	cmd := []string{
-		"find", ".", "-maxdepth", "4",
-		"(", "-name", ".*", "-not", "-name", ".", ")", "-prune", "-o",
-		"-type", "d", "(",
-		"-name", "node_modules", "-o",
-		"-name", "bin", "-o",
-		"-name", "obj", "-o",
-		"-name", "__pycache__", "-o",
-		"-name", "venv", "-o",
-		"-name", ".venv",
-		")", "-prune", "-o",
-		"-exec", "stat", "-c", "%n:%F", "{}", "+",
-	}
-
-	execResp, err := s.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-		Cmd:          cmd,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          false,
-		WorkingDir:   "/workspace",
-	})
+func (s *Service) GetFileTree(ctx context.Context, containerID string, ex Exclusions) ([]*FileNode, error) {
+	m, err := s.matcher(ctx, containerID, ex)
 	if err != nil {
-		return nil, fmt.Errorf("exec create: %w", err)
+		return nil, err
 	}
 
-	hijack, err := s.cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{
-		Tty: false,
-	})
+	// FIX: Alpine Linux (BusyBox) не підтримує -printf.
+	// Використовуємо -exec stat, щоб отримати шлях, тип і метадані файлу
+	// (див. statFormat), а для симлінків ще й ціль (symlinkScript).
+	cmd := append([]string{"find", ".", "-mindepth", "1", "-maxdepth", "4"}, ex.pruneArgs(false)...)
+	cmd = append(cmd,
+		"-exec", "stat", "-c", statFormat, "{}", "+",
+		"-type", "l", "-exec", "sh", "-c", symlinkScript, "sh", "{}", "+",
+	)
+
+	res, err := docker.RunIn(ctx, s.cli, containerID, "", workspace.Root, cmd)
 	if err != nil {
-		return nil, fmt.Errorf("exec attach: %w", err)
-	}
-	defer hijack.Close()
-
-	var stdout, stderr bytes.Buffer
-	if _, err := stdcopy.StdCopy(&stdout, &stderr, hijack.Reader); err != nil {
-		return nil, fmt.Errorf("read exec output: %w", err)
+		return nil, err
 	}
 
 	// Логуємо помилки, якщо find впав
-	if stderr.Len() > 0 {
-		log.Printf("[FileTree] stderr: %s", stderr.String())
+	if len(res.Stderr) > 0 {
+		log.Printf("[FileTree] stderr: %s", res.Stderr)
 	}
 
-	return buildTree(stdout.String())
+	return buildTree(string(res.Stdout), m)
 }
 
-func buildTree(output string) ([]*FileNode, error) {
+func buildTree(output string, m *matcher) ([]*FileNode, error) {
 	dirs := make(map[string]*FileNode)
+	hidden := make(map[string]bool)
 	topLevel := make([]*FileNode, 0)
 
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	sort.Strings(lines)
-
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		// Формат лінії: ./src/main.ts:regular file  АБО  ./src:directory
-		parts := strings.Split(line, ":")
-		if len(parts) < 2 {
-			continue
-		}
-
-		rawPath := parts[0]
-		rawType := parts[1]
+	// Сортуємо за шляхом, щоб батьківська тека йшла перед вмістом
+	nodes := parseStat(output)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Path < nodes[j].Path })
 
-		// Очищуємо шлях від "./" на початку
-		relPath := strings.TrimPrefix(rawPath, "./")
-		if relPath == "." || relPath == "" {
+	for _, node := range nodes {
+		// Вміст виключеної теки теж пропускаємо
+		parentPath := path.Dir(node.Path)
+		if hidden[parentPath] || m.excluded(node.Path, node.Type == "folder") {
+			hidden[node.Path] = true
 			continue
 		}
 
-		node := &FileNode{
-			Name: path.Base(relPath),
-			Path: relPath,
-		}
-
-		// Визначаємо тип
-		if strings.Contains(rawType, "directory") {
-			node.Type = "folder"
+		if node.Type == "folder" {
 			node.Children = make([]*FileNode, 0)
-			dirs[relPath] = node
-		} else {
-			node.Type = "file"
+			dirs[node.Path] = node
 		}
 
 		// Будуємо дерево
-		parentPath := path.Dir(relPath)
 		if parentPath == "." {
 			topLevel = append(topLevel, node)
 		} else if parent, ok := dirs[parentPath]; ok {
@@ -164,33 +168,175 @@ func buildTree(output string) ([]*FileNode, error) {
 	return topLevel, nil
 }
 
-func (s *Service) ReadFile(ctx context.Context, containerID, filePath string) (string, error) {
+// listNamesScript prints "d|name" for each folder (or symlink to one) and
+// "f|name" for every other entry of the directory $1, unsorted, then runs
+// gitignoreScript on the remaining arguments. Only shell builtins run per
+// entry, so this stays cheap for large folders. Exit status 2 means the
+// directory does not exist and 3 that it is not a directory.
+const listNamesScript = `[ -d "$1" ] || { [ -e "$1" ] && exit 3; exit 2; }
+cd "$1" || exit 1
+for f in * .[!.]* ..?*; do
+  if [ -d "$f" ]; then echo "d|$f"; elif [ -e "$f" ] || [ -L "$f" ]; then echo "f|$f"; fi
+done
+shift
+` + gitignoreScript
+
+// statEntriesScript describes the entries given after $1..$3 inside the
+// directory $1 with statFormat ($2) and symlinkScript ($3).
+const statEntriesScript = `cd "$1" || exit 2
+fmt=$2 links=$3; shift 3
+find "$@" -maxdepth 0 -exec stat -c "$fmt" {} + -type l -exec sh -c "$links" sh {} +`
+
+// ListDir returns the immediate children of dirPath ("" or "." for the
+// workspace root), at most limit of them, starting after cursor, which is
+// the NextCursor of the previous page. Only the entries themselves are
+// checked against ex, so an excluded folder can still be opened directly.
+//
+// Listing the names is cheap, so each page lists, filters and sorts them
+// all, and only the entries on the page are passed to stat.
+func (s *Service) ListDir(ctx context.Context, containerID, dirPath, cursor string, limit int, ex Exclusions) (*DirListing, error) {
+	if dirPath == "" {
+		dirPath = "."
+	}
+	if err := validatePath(dirPath); err != nil {
+		return nil, err
+	}
+	dirPath = path.Clean(dirPath)
+	var after string
+	if cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		after = string(b)
+	}
+
+	absDir := path.Join(workspace.Root, dirPath)
+	cmd := []string{"sh", "-c", listNamesScript, "sh", absDir}
+	if ex.GitIgnore {
+		cmd = append(cmd, gitignorePaths(ancestors(dirPath))...)
+	}
+	res, err := docker.Run(ctx, s.cli, containerID, "", cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch res.ExitCode {
+	case 0:
+	case 2:
+		return nil, ErrNotFound
+	case 3:
+		return nil, ErrNotDirectory
+	default:
+		return nil, fmt.Errorf("list %s failed (exit %d): %s", dirPath, res.ExitCode, strings.TrimSpace(string(res.Stderr)))
+	}
+
+	// The .gitignore files start at the first header line.
+	names, gitignores := string(res.Stdout), ""
+	if i := strings.Index("\n"+names, "\n==> "); i >= 0 {
+		names, gitignores = names[:i], names[i:]
+	}
+	m, err := newMatcher(ex, parseGitignores(gitignores))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(names, "\n") {
+		kind, name, ok := strings.Cut(line, "|")
+		if !ok || (kind != "d" && kind != "f") {
+			continue
+		}
+		if m.excluded(path.Join(dirPath, name), kind == "d") {
+			continue
+		}
+		keys = append(keys, listKey(name, kind == "d"))
+	}
+	sort.Strings(keys)
+
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && keys[start] == after {
+		start++
+	}
+	end := min(start+limit, len(keys))
+	page := keys[start:end]
+	listing := &DirListing{
+		Path:    dirPath,
+		Entries: make([]*FileNode, 0, len(page)),
+		Total:   len(keys),
+	}
+	if end < len(keys) {
+		listing.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(keys[end-1]))
+	}
+	if len(page) == 0 {
+		return listing, nil
+	}
+
+	cmd = []string{"sh", "-c", statEntriesScript, "sh", absDir, statFormat, symlinkScript}
+	for _, key := range page {
+		cmd = append(cmd, "./"+key[1:])
+	}
+	res, err = docker.Run(ctx, s.cli, containerID, "", cmd)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*FileNode)
+	for _, node := range parseStat(string(res.Stdout)) {
+		byName[node.Path] = node
+	}
+	// Entries removed since they were listed are left out of the page.
+	for _, key := range page {
+		if node, ok := byName[key[1:]]; ok {
+			node.Path = path.Join(dirPath, node.Name)
+			listing.Entries = append(listing.Entries, node)
+		}
+	}
+	return listing, nil
+}
+
+// listKey orders folders before files, then by name. A cursor is the key
+// of the last entry of a page, so paging stays stable while entries are
+// added or removed. key[1:] is the name.
+func listKey(name string, dir bool) string {
+	if dir {
+		return "0" + name
+	}
+	return "1" + name
+}
+
+// ReadFile returns the file's contents as stored, failing with a
+// *TooLargeError rather than truncating files over maxFileSize.
+func (s *Service) ReadFile(ctx context.Context, containerID, filePath string) ([]byte, error) {
 	if err := validatePath(filePath); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	absPath := "/workspace/" + filePath
+	absPath := path.Join(workspace.Root, filePath)
 
 	tarStream, _, err := s.cli.CopyFromContainer(ctx, containerID, absPath)
 	if err != nil {
-		return "", fmt.Errorf("copy from container: %w", err)
+		return nil, fmt.Errorf("copy from container: %w", err)
 	}
 	defer tarStream.Close()
 
 	tr := tar.NewReader(tarStream)
-	if _, err := tr.Next(); err != nil {
-		return "", fmt.Errorf("read tar header: %w", err)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("read tar header: %w", err)
+	}
+	if hdr.Size > maxFileSize {
+		head, _ := io.ReadAll(io.LimitReader(tr, 8000))
+		return nil, &TooLargeError{Size: hdr.Size, Binary: isBinary(head)}
 	}
 
 	content, err := io.ReadAll(io.LimitReader(tr, maxFileSize))
 	if err != nil {
-		return "", fmt.Errorf("read file content: %w", err)
+		return nil, fmt.Errorf("read file content: %w", err)
 	}
 
-	return string(content), nil
+	return content, nil
 }
 
-func (s *Service) WriteFile(ctx context.Context, containerID, filePath, content string) error {
+func (s *Service) WriteFile(ctx context.Context, containerID, filePath string, content []byte) error {
 	if err := validatePath(filePath); err != nil {
 		return err
 	}
@@ -207,17 +353,14 @@ func (s *Service) WriteFile(ctx context.Context, containerID, filePath, content
 	if err := tw.WriteHeader(hdr); err != nil {
 		return fmt.Errorf("write tar header: %w", err)
 	}
-	if _, err := tw.Write([]byte(content)); err != nil {
+	if _, err := tw.Write(content); err != nil {
 		return fmt.Errorf("write tar content: %w", err)
 	}
 	if err := tw.Close(); err != nil {
 		return fmt.Errorf("close tar writer: %w", err)
 	}
 
-	destDir := "/workspace/" + path.Dir(filePath)
-	if destDir == "/workspace/." {
-		destDir = "/workspace"
-	}
+	destDir := path.Join(workspace.Root, path.Dir(filePath))
 
 	if err := s.cli.CopyToContainer(ctx, containerID, destDir, &buf, container.CopyToContainerOptions{}); err != nil {
 		return fmt.Errorf("copy to container: %w", err)
@@ -226,60 +369,46 @@ func (s *Service) WriteFile(ctx context.Context, containerID, filePath, content
 	return nil
 }
 
-func (s *Service) SearchFiles(ctx context.Context, containerID, query string, matchCase bool, matchWord bool) ([]*SearchResult, error) {
+func (s *Service) SearchFiles(ctx context.Context, containerID, query string, matchCase bool, matchWord bool, ex Exclusions) ([]*SearchResult, error) {
 	if query == "" {
 		return []*SearchResult{}, nil
 	}
 
-	grepFlags := "-Hn -F"
+	m, err := s.matcher(ctx, containerID, ex)
+	if err != nil {
+		return nil, err
+	}
+
+	grepArgs := []string{"grep", "-H", "-n", "-F"}
 	if !matchCase {
-		grepFlags += " -i"
+		grepArgs = append(grepArgs, "-i")
 	}
 	if matchWord {
-		grepFlags += " -w"
+		grepArgs = append(grepArgs, "-w")
 	}
 
 	// Use find + grep since Alpine Linux (BusyBox) grep doesn't support --exclude-dir
-	cmd := []string{
-		"sh", "-c",
-		fmt.Sprintf("find . \\( -name '.*' -not -name '.' \\) -prune -o -type d \\( -name 'node_modules' -o -name 'bin' -o -name 'obj' -o -name '__pycache__' -o -name 'venv' -o -name '.venv' \\) -prune -o -type f -exec grep %s '%s' {} + 2>/dev/null || true",
-			grepFlags, escapeForShell(query)),
-	}
-
-	execResp, err := s.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-		Cmd:          cmd,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          false,
-		WorkingDir:   "/workspace",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("exec create: %w", err)
-	}
+	cmd := append([]string{"find", ".", "-mindepth", "1"}, ex.pruneArgs(false)...)
+	cmd = append(cmd, "-type", "f", "-exec")
+	cmd = append(cmd, grepArgs...)
+	cmd = append(cmd, "-e", query, "{}", "+")
 
-	hijack, err := s.cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{
-		Tty: false,
-	})
+	res, err := docker.RunIn(ctx, s.cli, containerID, "", workspace.Root, cmd)
 	if err != nil {
-		return nil, fmt.Errorf("exec attach: %w", err)
+		return nil, err
 	}
-	defer hijack.Close()
 
-	var stdout, stderr bytes.Buffer
-	if _, err := stdcopy.StdCopy(&stdout, &stderr, hijack.Reader); err != nil {
-		return nil, fmt.Errorf("read exec output: %w", err)
+	if len(res.Stderr) > 0 {
+		log.Printf("[Search] stderr: %s", res.Stderr)
 	}
 
-	if stderr.Len() > 0 {
-		log.Printf("[Search] stderr: %s", stderr.String())
+	results := make([]*SearchResult, 0)
+	for _, r := range parseGrepOutput(string(res.Stdout)) {
+		if !m.excludedFile(r.File) {
+			results = append(results, r)
+		}
 	}
-
-	return parseGrepOutput(stdout.String()), nil
-}
-
-func escapeForShell(s string) string {
-	// Simple shell escaping - replace single quotes with '\''
-	return strings.ReplaceAll(s, "'", "'\\''")
+	return results, nil
 }
 
 func parseGrepOutput(output string) []*SearchResult {
@@ -0,0 +1,155 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
+)
+
+// statFormat makes stat print "type|size|mode|mtime|owner|name". The name
+// comes last because it may itself contain "|".
+const statFormat = "%F|%s|%a|%Y|%U|%n"
+
+// symlinkScript prints "L|d|name" ("L|f|name" unless the link points to a
+// directory) and the link's target on the next line, for each symlink
+// passed as an argument.
+const symlinkScript = `for f; do [ -d "$f" ] && d=d || d=f; printf 'L|%s|%s\n%s\n' "$d" "$f" "$(readlink "$f")"; done`
+
+// statScript describes the single path $1 with statFormat, then prints "B"
+// if it is a regular file with a NUL byte in its first 8000 bytes (the
+// same heuristic git uses), then the symlink lines. Exit status 2 means the
+// path does not exist.
+const statScript = `f=$1
+[ -e "$f" ] || [ -L "$f" ] || exit 2
+stat -c '` + statFormat + `' "$f" || exit 1
+if [ -f "$f" ] && [ "$(head -c 8000 "$f" | tr -dc '\000' | wc -c)" -gt 0 ]; then echo B; fi
+if [ -L "$f" ]; then set -- "$f"; ` + symlinkScript + `; fi`
+
+// binaryExts are extensions of files that are binary in practice; listings
+// use them as the "binary" hint since they do not read file contents.
+var binaryExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".ico": true, ".webp": true,
+	".pdf": true, ".zip": true, ".gz": true, ".tgz": true, ".tar": true, ".7z": true, ".rar": true, ".jar": true,
+	".exe": true, ".dll": true, ".so": true, ".dylib": true, ".o": true, ".a": true, ".class": true, ".pyc": true,
+	".wasm": true, ".bin": true, ".db": true, ".sqlite": true,
+	".mp3": true, ".mp4": true, ".wav": true, ".ogg": true, ".webm": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true,
+}
+
+// isBinary applies statScript's heuristic to contents already read.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+// parseStat turns the output of statFormat and symlinkScript into nodes,
+// in the order stat printed them. Paths are taken as printed, without a
+// leading "./".
+func parseStat(output string) []*FileNode {
+	var nodes []*FileNode
+	byPath := make(map[string]*FileNode)
+	linkIsDir := make(map[string]bool)
+	targets := make(map[string]string)
+
+	lines := strings.Split(output, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if rest, ok := strings.CutPrefix(line, "L|"); ok {
+			kind, name, _ := strings.Cut(rest, "|")
+			name = strings.TrimPrefix(name, "./")
+			linkIsDir[name] = kind == "d"
+			if i+1 < len(lines) {
+				i++
+				targets[name] = lines[i]
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "|", 6)
+		if len(parts) < 6 {
+			continue
+		}
+		rawType, name := parts[0], strings.TrimPrefix(parts[5], "./")
+		if name == "" || name == "." {
+			continue
+		}
+		node := &FileNode{
+			Name:  path.Base(name),
+			Path:  name,
+			Type:  "file",
+			Owner: parts[4],
+		}
+		if mode, err := strconv.ParseUint(parts[2], 8, 32); err == nil {
+			node.Mode = fmt.Sprintf("%04o", mode)
+			node.Executable = mode&0o111 != 0 && strings.Contains(rawType, "regular")
+		}
+		if sec, err := strconv.ParseInt(parts[3], 10, 64); err == nil {
+			node.ModTime = time.Unix(sec, 0).UTC()
+		}
+		switch {
+		case strings.Contains(rawType, "directory"):
+			node.Type = "folder"
+		case strings.Contains(rawType, "symbolic link"):
+			node.Symlink = true
+		default:
+			node.Size, _ = strconv.ParseInt(parts[1], 10, 64)
+			node.Binary = binaryExts[strings.ToLower(path.Ext(name))]
+		}
+		nodes = append(nodes, node)
+		byPath[name] = node
+	}
+
+	// Symlinks take the type of what they point to.
+	for name, isDir := range linkIsDir {
+		if node, ok := byPath[name]; ok {
+			node.Target = targets[name]
+			if isDir {
+				node.Type = "folder"
+			}
+		}
+	}
+	return nodes
+}
+
+// StatFile describes a single path. Unlike listings, the binary hint is
+// based on the file's contents.
+func (s *Service) StatFile(ctx context.Context, containerID, filePath string) (*FileNode, error) {
+	if err := validatePath(filePath); err != nil {
+		return nil, err
+	}
+	filePath = path.Clean(filePath)
+
+	res, err := docker.Run(ctx, s.cli, containerID, "", []string{
+		"sh", "-c", statScript, "sh", path.Join(workspace.Root, filePath),
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch res.ExitCode {
+	case 0:
+	case 2:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("stat %s failed (exit %d): %s", filePath, res.ExitCode, strings.TrimSpace(string(res.Stderr)))
+	}
+
+	out := string(res.Stdout)
+	nodes := parseStat(out)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("stat %s: unexpected output %q", filePath, out)
+	}
+	node := nodes[0]
+	node.Name = path.Base(filePath)
+	node.Path = filePath
+	if node.Type == "file" {
+		lines := strings.SplitN(out, "\n", 3)
+		node.Binary = len(lines) > 1 && lines[1] == "B"
+	}
+	return node, nil
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SessionInfo describes a live terminal session for operators.
+type SessionInfo struct {
+	ID           string       `json:"id"`
+	ContainerID  string       `json:"containerId"`
+	ExecID       string       `json:"execId,omitempty"`
+	Mode         string       `json:"mode"`
+	Command      string       `json:"command"`
+	User         string       `json:"user,omitempty"`
+	Owner        string       `json:"owner,omitempty"`
+	Cwd          string       `json:"cwd,omitempty"`
+	Title        string       `json:"title,omitempty"`
+	Created      time.Time    `json:"created"`
+	LastActivity time.Time    `json:"lastActivity"`
+	BytesIn      int64        `json:"bytesIn"`
+	BytesOut     int64        `json:"bytesOut"`
+	Clients      []ClientInfo `json:"clients"`
+}
+
+// ClientInfo describes one WebSocket attached to a session.
+type ClientInfo struct {
+	Addr         string    `json:"addr"`
+	Role         Role      `json:"role"`
+	Connected    time.Time `json:"connected"`
+	LastActivity time.Time `json:"lastActivity"`
+	BytesIn      int64     `json:"bytesIn"`
+	BytesOut     int64     `json:"bytesOut"`
+}
+
+// Info snapshots the session and its attached clients.
+func (s *Session) Info() SessionInfo {
+	info := SessionInfo{
+		ID:           s.ID,
+		ContainerID:  s.ContainerID,
+		ExecID:       s.ExecID,
+		Mode:         "exec",
+		Command:      s.spec.Cmd,
+		User:         s.spec.User,
+		Owner:        s.Owner,
+		Created:      s.Created,
+		LastActivity: s.LastActivity(),
+		BytesIn:      s.bytesIn.Load(),
+		BytesOut:     s.bytesOut.Load(),
+		Clients:      []ClientInfo{},
+	}
+	if s.spec.Main {
+		info.Mode = "main"
+	}
+	s.mu.Lock()
+	info.Cwd, info.Title = s.cwd, s.title
+	for c := range s.clients {
+		info.Clients = append(info.Clients, ClientInfo{
+			Addr:         c.addr,
+			Role:         c.role,
+			Connected:    c.connected,
+			LastActivity: time.Unix(0, c.lastActivity.Load()),
+			BytesIn:      c.bytesIn.Load(),
+			BytesOut:     c.bytesOut.Load(),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(info.Clients, func(i, j int) bool { return info.Clients[i].Connected.Before(info.Clients[j].Connected) })
+	return info
+}
+
+// adminOnly serves h only to requests carrying "Authorization: Bearer
+// {token}". Session IDs are enough to join a terminal, so without a
+// configured token the admin endpoints do not exist at all.
+func adminOnly(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !isAdmin(token, r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the admin token. It is always false
+// when no token is configured.
+func isAdmin(token string, r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+type sessionList struct {
+	Sessions []SessionInfo `json:"sessions"`
+	// ByContainer counts sessions per container.
+	ByContainer map[string]int `json:"byContainer"`
+}
+
+// sessionsHandler lists live sessions: GET /sessions[?id={container}]
+func sessionsHandler(reg *registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		list := sessionList{Sessions: []SessionInfo{}, ByContainer: map[string]int{}}
+		for _, s := range reg.list(r.URL.Query().Get("id")) {
+			list.Sessions = append(list.Sessions, s.Info())
+			list.ByContainer[s.ContainerID]++
+		}
+		sort.Slice(list.Sessions, func(i, j int) bool { return list.Sessions[i].Created.Before(list.Sessions[j].Created) })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			log.Printf("[sessions] encode error: %v", err)
+		}
+	}
+}
+
+// sessionInspectHandler describes one session: GET /sessions/inspect?session={id}
+func sessionInspectHandler(reg *registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sess, ok := lookupSession(w, r, reg)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sess.Info()); err != nil {
+			log.Printf("[sessions] encode error: %v", err)
+		}
+	}
+}
+
+// sessionKillHandler terminates a session and disconnects its clients:
+// POST /sessions/kill?session={id}
+func sessionKillHandler(reg *registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sess, ok := lookupSession(w, r, reg)
+		if !ok {
+			return
+		}
+
+		log.Printf("[sessions] killing session %s (container %s) on request from %s", sess.ID, sess.ContainerID, r.RemoteAddr)
+		sess.Terminate(reasonKilled)
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+func lookupSession(w http.ResponseWriter, r *http.Request, reg *registry) (*Session, bool) {
+	id := r.URL.Query().Get("session")
+	if id == "" {
+		http.Error(w, `missing "session" query parameter`, http.StatusBadRequest)
+		return nil, false
+	}
+	sess := reg.get(id)
+	if sess == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil, false
+	}
+	return sess, true
+}
@@ -3,12 +3,12 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
@@ -21,33 +21,55 @@ const (
 	writeDeadline = 10 * time.Second
 )
 
-type resizeMsg struct {
-	Type string `json:"type"`
-	Cols uint   `json:"cols"`
-	Rows uint   `json:"rows"`
-}
-
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  readBufSize,
 	WriteBufferSize: readBufSize,
+	Subprotocols:    []string{ProtocolV1, ProtocolK8sV4, ProtocolK8sBase64},
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-func Register(mux *http.ServeMux, cli *client.Client) {
-	mux.HandleFunc("/attach", attachHandler(cli))
+func Register(mux *http.ServeMux, cli *client.Client, cfg Config) {
+	reg := newRegistry(cli, cfg)
+	mux.HandleFunc("/attach", attachHandler(reg))
+	mux.HandleFunc("/attach/mux", muxHandler(reg))
 	mux.HandleFunc("/resize", resizeHandler(cli))
+	mux.HandleFunc("/signal", signalHandler(reg))
+	mux.HandleFunc("/sessions", adminOnly(cfg.AdminToken, sessionsHandler(reg)))
+	mux.HandleFunc("/sessions/inspect", adminOnly(cfg.AdminToken, sessionInspectHandler(reg)))
+	mux.HandleFunc("/sessions/kill", adminOnly(cfg.AdminToken, sessionKillHandler(reg)))
+	mux.HandleFunc("/metrics", adminOnly(cfg.AdminToken, metricsHandler(reg)))
+	mux.HandleFunc("/recordings", recordingsHandler(cfg.RecordingDir))
+	mux.HandleFunc("/recordings/download", recordingDownloadHandler(cfg.RecordingDir))
 	mux.HandleFunc("/healthz", healthHandler(cli))
 }
 
-func attachHandler(cli *client.Client) http.HandlerFunc {
+func attachHandler(reg *registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		containerID := r.URL.Query().Get("id")
 		if containerID == "" {
 			http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
 			return
 		}
+		sessionID := r.URL.Query().Get("session")
 
-		ws, err := upgrader.Upgrade(w, r, nil)
+		opts, err := parseClientOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		role, window := opts.role, opts.window
+
+		var spec execSpec
+		if sessionID == "" {
+			if spec, err = reg.cfg.Shell.resolve(r.URL.Query()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		up := upgrader
+		up.EnableCompression = reg.cfg.Compression
+		ws, err := up.Upgrade(w, r, nil)
 		if err != nil {
 			log.Printf("[attach] websocket upgrade failed: %v", err)
 			return
@@ -57,107 +79,179 @@ func attachHandler(cli *client.Client) http.HandlerFunc {
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
-		log.Printf("[attach] creating exec in container %s", containerID)
-
-		// Create an interactive shell exec inside the container.
-		// The container runs "sleep infinity" as its main process;
-		// we exec /bin/sh with a TTY to get an actual terminal session.
-		execResp, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-			Cmd:          []string{"/bin/sh"},
-			AttachStdin:  true,
-			AttachStdout: true,
-			AttachStderr: true,
-			Tty:          true,
-			Env:          []string{"TERM=xterm"},
-			WorkingDir:   "/workspace",
-		})
+		cd := codecFor(ws.Subprotocol(), opts.utf8, opts.announce)
+		if _, v1 := cd.(v1Codec); !v1 {
+			// Only pty.v1 has a way to acknowledge output.
+			window = 0
+		}
+
+		sess, err := reg.acquire(containerID, sessionID, r.Header.Get(reg.cfg.UserHeader), spec)
 		if err != nil {
-			log.Printf("[attach] exec create error: %v", err)
-			_ = ws.WriteMessage(websocket.TextMessage, []byte("exec create error: "+err.Error()))
+			log.Printf("[attach] %v", err)
+			writeEvent(ws, cd, errorEvent("%v", err))
+			var limitErr *limitError
+			if errors.As(err, &limitErr) {
+				_ = ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "session limit reached: "+limitErr.scope),
+					time.Now().Add(time.Second))
+			}
 			return
 		}
-		execID := execResp.ID
+		if sessionID != "" {
+			log.Printf("[attach] %s joining session %s (exec %s) in container %s as %s", r.RemoteAddr, sess.ID, sess.ExecID, containerID, role)
+		} else {
+			// Whoever starts the shell owns it; role only applies when joining.
+			role = RoleOwner
+			if spec.Main {
+				log.Printf("[attach] attached to main process of container %s as session %s", containerID, sess.ID)
+			} else {
+				log.Printf("[attach] attached to exec %s in container %s as session %s", sess.ExecID, containerID, sess.ID)
+			}
+		}
 
-		hijack, err := cli.ContainerExecAttach(ctx, execID, container.ExecAttachOptions{
-			Tty: true,
+		c := newSubscriber(ws, cd, role, r.RemoteAddr, reg.cfg.subscriberOptions(role, window, opts.utf8))
+		defer c.close()
+
+		// Any message or pong proves the peer is alive; a half-open
+		// connection times out the read below after PongWait.
+		alive := func() {
+			if reg.cfg.PongWait > 0 {
+				_ = ws.SetReadDeadline(time.Now().Add(reg.cfg.PongWait))
+			}
+		}
+		alive()
+		ws.SetPongHandler(func(string) error {
+			alive()
+			return nil
 		})
-		if err != nil {
-			log.Printf("[attach] exec attach error: %v", err)
-			_ = ws.WriteMessage(websocket.TextMessage, []byte("exec attach error: "+err.Error()))
+
+		// Tell the client which session it is on so it can re-attach later.
+		c.sendEvent(sessionEvent(sess.ID, role))
+
+		if err := sess.Join(c); err != nil {
+			c.sendEvent(errorEvent("%v", err))
 			return
 		}
-		defer hijack.Close()
-
-		log.Printf("[attach] attached to exec %s in container %s, starting bidirectional pipe", execID, containerID)
-
-		var wg sync.WaitGroup
-		wg.Add(2)
-
-		// Docker → WebSocket
-		go func() {
-			defer wg.Done()
-			defer cancel()
-			buf := make([]byte, readBufSize)
-			for {
-				n, readErr := hijack.Reader.Read(buf)
-				if n > 0 {
-					_ = ws.SetWriteDeadline(time.Now().Add(writeDeadline))
-					if writeErr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); writeErr != nil {
-						log.Printf("[attach] write to websocket failed: %v", writeErr)
-						return
-					}
-				}
-				if readErr != nil {
-					if readErr != io.EOF {
-						log.Printf("[attach] read from docker failed: %v", readErr)
-					}
-					return
+		defer sess.Leave(c)
+
+		// WebSocket → Docker. Docker → WebSocket is driven by the session's
+		// pump so that it keeps running while no client is attached.
+		for {
+			mt, payload, readErr := ws.ReadMessage()
+			if readErr != nil {
+				if websocket.IsUnexpectedCloseError(readErr, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.Printf("[attach] read from websocket failed: %v", readErr)
 				}
+				break
+			}
+			if mt == websocket.CloseMessage {
+				break
+			}
+			alive()
+			c.received(0)
+			if len(payload) == 0 {
+				continue
 			}
-		}()
-
-		// WebSocket → Docker
-		go func() {
-			defer wg.Done()
-			defer cancel()
-			for {
-				mt, payload, readErr := ws.ReadMessage()
-				if readErr != nil {
-					if websocket.IsUnexpectedCloseError(readErr, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-						log.Printf("[attach] read from websocket failed: %v", readErr)
-					}
-					return
-				}
-				if mt == websocket.CloseMessage {
-					return
-				}
-				if len(payload) == 0 {
-					continue
-				}
 
-				// JSON messages are resize commands — use exec resize, not container resize
-				if payload[0] == '{' {
-					var msg resizeMsg
-					if json.Unmarshal(payload, &msg) == nil && msg.Type == "resize" {
-						if err := cli.ContainerExecResize(ctx, execID, container.ResizeOptions{
-							Height: msg.Rows,
-							Width:  msg.Cols,
-						}); err != nil {
-							log.Printf("[attach] resize failed: %v", err)
-						}
-						continue
-					}
-				}
+			input, ctrl, err := cd.decode(mt, payload)
+			if err != nil {
+				c.sendEvent(errorEvent("%v", err))
+				continue
+			}
+			if ctrl != nil {
+				handleControl(ctx, sess, c, ctrl)
+				continue
+			}
 
-				if _, writeErr := hijack.Conn.Write(payload); writeErr != nil {
-					log.Printf("[attach] write to docker failed: %v", writeErr)
-					return
-				}
+			if !role.canWrite() {
+				continue
+			}
+			c.received(len(input))
+			if reg.audit != nil {
+				reg.audit.input(sess, c, input)
+			}
+			if _, writeErr := sess.Write(input); writeErr != nil {
+				log.Printf("[attach] write to docker failed: %v", writeErr)
+				break
 			}
-		}()
+		}
+
+		log.Printf("[attach] client disconnected from session %s (container %s)", sess.ID, containerID)
+	}
+}
+
+// clientOptions are the per-connection settings a client picks when it
+// attaches, shared by /attach and multiplexed channels.
+type clientOptions struct {
+	role     Role
+	window   int
+	utf8     bool
+	announce bool
+}
+
+// parseClientOptions reads "role", "window", "encoding" and "announce".
+func parseClientOptions(q url.Values) (clientOptions, error) {
+	opts := clientOptions{role: RoleOwner}
+	if v := q.Get("role"); v != "" {
+		var err error
+		if opts.role, err = parseRole(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := q.Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < readBufSize {
+			return opts, fmt.Errorf(`invalid "window" parameter, must be at least %d`, readBufSize)
+		}
+		opts.window = n
+	}
+	switch v := q.Get("encoding"); v {
+	case "", "binary":
+	case "utf8":
+		opts.utf8 = true
+	default:
+		return opts, fmt.Errorf(`invalid "encoding" parameter %q, must be "binary" or "utf8"`, v)
+	}
+	if v := q.Get("announce"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf(`invalid "announce" parameter %q`, v)
+		}
+		opts.announce = b
+	}
+	return opts, nil
+}
+
+// handleControl applies a control message from c to the session.
+func handleControl(ctx context.Context, sess *Session, c *subscriber, msg *controlMsg) {
+	switch msg.Type {
+	case "resize":
+		// Use exec resize, not container resize.
+		if !c.role.canResize() {
+			return
+		}
+		if err := sess.Resize(ctx, msg.Cols, msg.Rows); err != nil {
+			log.Printf("[attach] resize failed: %v", err)
+			c.sendEvent(errorEvent("resize failed: %v", err))
+		}
+
+	case "signal":
+		if !c.role.canWrite() {
+			c.sendEvent(errorEvent("role %s may not send signals", c.role))
+			return
+		}
+		if err := sess.Signal(ctx, msg.Signal, msg.Target); err != nil {
+			c.sendEvent(errorEvent("signal failed: %v", err))
+		}
+
+	case "ack":
+		sess.Ack(c, msg.Bytes)
+
+	case "ping":
+		c.sendEvent(serverEvent{Type: "pong", ID: msg.ID})
 
-		wg.Wait()
-		log.Printf("[attach] session ended for container %s (exec %s)", containerID, execID)
+	default:
+		c.sendEvent(errorEvent("unknown control message type %q", msg.Type))
 	}
 }
 
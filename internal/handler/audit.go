@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the audit log. Type "input" records raw
+// keystrokes as received; type "command" records a reconstructed line.
+type auditRecord struct {
+	Time        time.Time `json:"ts"`
+	Type        string    `json:"type"`
+	SessionID   string    `json:"session"`
+	ContainerID string    `json:"container"`
+	Owner       string    `json:"owner,omitempty"`
+	Client      string    `json:"client"`
+	Role        Role      `json:"role"`
+	Data        string    `json:"data,omitempty"`
+	Line        string    `json:"line,omitempty"`
+}
+
+// auditLog appends JSON lines to a file, rotating it to path.1, path.2, ...
+// once it grows past maxBytes and keeping at most maxFiles old files.
+type auditLog struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openAuditLog(path string, maxBytes int64, maxFiles int) (*auditLog, error) {
+	a := &auditLog{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and starts a new
+// file. a.mu must be held.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		log.Printf("[audit] close %s: %v", a.path, err)
+	}
+	a.f = nil
+	if a.maxFiles > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", a.path, a.maxFiles))
+		for i := a.maxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return fmt.Errorf("rotate audit log: %w", err)
+	}
+	return a.open()
+}
+
+func (a *auditLog) write(rec auditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil && a.maxBytes > 0 && a.size+int64(len(line)) > a.maxBytes && a.size > 0 {
+		if err := a.rotate(); err != nil {
+			log.Printf("[audit] %v", err)
+		}
+	}
+	if a.f == nil {
+		// A failed rotation left no file open; retry on the next record.
+		if err := a.open(); err != nil {
+			log.Printf("[audit] %v", err)
+			return
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Printf("[audit] write %s: %v", a.path, err)
+	}
+}
+
+// input records keystrokes c sent to s and any command lines they complete.
+func (a *auditLog) input(s *Session, c *subscriber, p []byte) {
+	rec := auditRecord{
+		Time:        time.Now().UTC(),
+		SessionID:   s.ID,
+		ContainerID: s.ContainerID,
+		Owner:       s.Owner,
+		Client:      c.addr,
+		Role:        c.role,
+	}
+
+	in := rec
+	in.Type = "input"
+	in.Data = string(p)
+	a.write(in)
+
+	for _, line := range c.line.feed(p) {
+		cmd := rec
+		cmd.Type = "command"
+		cmd.Line = line
+		a.write(cmd)
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"os"
+	"time"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/envconfig"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
+)
+
+// Config holds the tunables for the terminal endpoints. Values are read
+// from the environment so they can be set next to PORT and DOCKER_HOST.
+type Config struct {
+	// SessionGrace is how long a session with no attached WebSocket is
+	// kept alive before its exec is reaped.
+	SessionGrace time.Duration
+	// ScrollbackSize is the number of most recent output bytes kept per
+	// session and replayed to a client when it attaches.
+	ScrollbackSize int
+	// RecordingDir is where asciicast recordings are written, one
+	// subdirectory per container. Recording is disabled when empty.
+	RecordingDir string
+	// PingInterval is how often the server pings each attached client.
+	PingInterval time.Duration
+	// PongWait is how long a client may stay silent (no message and no pong)
+	// before it is considered dead and disconnected.
+	PongWait time.Duration
+	// IdleTimeout terminates a session with no input or output for this
+	// long. Zero disables it.
+	IdleTimeout time.Duration
+	// CoalesceDelay batches output arriving within this window into one
+	// frame. Zero sends output as soon as it is read.
+	CoalesceDelay time.Duration
+	// MaxFrameSize caps the terminal bytes carried by one frame.
+	MaxFrameSize int
+	// MaxQueueBytes is how far a client without flow control may fall
+	// behind before it is disconnected.
+	MaxQueueBytes int
+	// Compression enables permessage-deflate for clients that offer it.
+	Compression bool
+	// UserHeader names the request header carrying the authenticated user,
+	// as set by the gateway in front of the proxy.
+	UserHeader string
+	// Limits caps concurrent sessions globally, per container and per user.
+	Limits Limits
+	// AuditLogPath enables the keystroke audit log, written as JSON lines
+	// to this file. Empty disables auditing.
+	AuditLogPath string
+	// AuditMaxBytes rotates the audit log once it reaches this size.
+	AuditMaxBytes int
+	// AuditMaxFiles is the number of rotated audit logs kept.
+	AuditMaxFiles int
+	// Shell restricts the command, user, env and cwd a client may request.
+	Shell ShellPolicy
+	// AdminToken is the bearer token required by the session admin API and
+	// /metrics. Those endpoints are disabled when it is empty.
+	AdminToken string
+}
+
+func LoadConfig() Config {
+	return Config{
+		SessionGrace:   envconfig.Duration("ATTACH_SESSION_GRACE", 2*time.Minute),
+		ScrollbackSize: envconfig.Int("ATTACH_SCROLLBACK_BYTES", 64*1024),
+		RecordingDir:   os.Getenv("ATTACH_RECORDING_DIR"),
+		PingInterval:   envconfig.Duration("ATTACH_PING_INTERVAL", 25*time.Second),
+		PongWait:       envconfig.Duration("ATTACH_PONG_WAIT", 60*time.Second),
+		IdleTimeout:    envconfig.Duration("ATTACH_IDLE_TIMEOUT", 30*time.Minute),
+		CoalesceDelay:  envconfig.Duration("ATTACH_COALESCE_DELAY", 5*time.Millisecond),
+		MaxFrameSize:   max(envconfig.Int("ATTACH_MAX_FRAME_BYTES", 32*1024), readBufSize),
+		MaxQueueBytes:  envconfig.Int("ATTACH_MAX_QUEUE_BYTES", 4*1024*1024),
+		Compression:    envconfig.Bool("ATTACH_COMPRESSION", false),
+		UserHeader:     envconfig.String("ATTACH_USER_HEADER", "X-User-ID"),
+		Limits: Limits{
+			Global:       envconfig.Int("ATTACH_MAX_SESSIONS", 0),
+			PerContainer: envconfig.Int("ATTACH_MAX_SESSIONS_PER_CONTAINER", 0),
+			PerUser:      envconfig.Int("ATTACH_MAX_SESSIONS_PER_USER", 0),
+		},
+		AuditLogPath:  os.Getenv("ATTACH_AUDIT_LOG"),
+		AuditMaxBytes: envconfig.Int("ATTACH_AUDIT_MAX_BYTES", 100*1024*1024),
+		AuditMaxFiles: envconfig.Int("ATTACH_AUDIT_MAX_FILES", 5),
+		Shell: ShellPolicy{
+			DefaultCommand: envconfig.String("ATTACH_DEFAULT_SHELL", "/bin/sh"),
+			Commands:       envconfig.List("ATTACH_ALLOWED_SHELLS", []string{"/bin/sh", "/bin/ash", "/bin/bash", "/bin/zsh"}),
+			Users:          envconfig.List("ATTACH_ALLOWED_USERS", nil),
+			EnvKeys:        envconfig.List("ATTACH_ALLOWED_ENV", []string{"TERM", "LANG", "LC_ALL", "TZ"}),
+			WorkdirRoot:    envconfig.String("ATTACH_WORKDIR", workspace.Root),
+			TrackCwd:       envconfig.Bool("ATTACH_TRACK_CWD", false),
+			AllowMain:      envconfig.Bool("ATTACH_ALLOW_MAIN", false),
+		},
+		AdminToken: os.Getenv("ATTACH_ADMIN_TOKEN"),
+	}
+}
+
+// subscriberOptions returns the delivery options for a client with role
+// that asked for the given flow-control window (0 for none) and, with utf8,
+// for output frames that are always valid UTF-8. A full window stalls the
+// whole session, so only clients that can write get one; read-only
+// viewers are disconnected at maxQueue instead.
+func (c Config) subscriberOptions(role Role, window int, utf8 bool) subscriberOptions {
+	if !role.canWrite() {
+		window = 0
+	}
+	return subscriberOptions{
+		pingInterval:  c.PingInterval,
+		coalesceDelay: c.CoalesceDelay,
+		maxFrame:      c.MaxFrameSize,
+		maxQueue:      c.MaxQueueBytes,
+		window:        window,
+		utf8:          utf8,
+	}
+}
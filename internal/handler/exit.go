@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+	"github.com/gorilla/websocket"
+)
+
+// Reasons reported in the exit event when a session ends.
+const (
+	reasonExited           = "exited"            // the shell exited on its own
+	reasonContainerStopped = "container_stopped" // the container is no longer running
+	reasonError            = "error"             // the Docker stream failed
+	reasonReaped           = "reaped"            // no client re-attached within the grace period
+	reasonIdle             = "idle"              // no input or output within the idle timeout
+	reasonKilled           = "killed"            // terminated through the admin API
+)
+
+// closeTerminated is the WebSocket close code used when the server ended the
+// session itself (see the reason for why). 4000-4999 is reserved for
+// application use by RFC 6455.
+const closeTerminated = 4000
+
+type exitStatus struct {
+	Code     *int
+	Reason   string
+	Message  string
+	Duration time.Duration
+}
+
+func (st exitStatus) event() serverEvent {
+	return serverEvent{
+		Type:       "exit",
+		ExitCode:   st.Code,
+		Reason:     st.Reason,
+		Message:    st.Message,
+		DurationMs: st.Duration.Milliseconds(),
+	}
+}
+
+// closeCode maps an exit reason to the WebSocket close code sent to clients.
+func (st exitStatus) closeCode() int {
+	switch st.Reason {
+	case reasonExited:
+		return websocket.CloseNormalClosure
+	case reasonContainerStopped:
+		return websocket.CloseGoingAway
+	case reasonError:
+		return websocket.CloseInternalServerErr
+	}
+	return closeTerminated
+}
+
+func (st exitStatus) closeText() string {
+	if st.Code != nil {
+		return fmt.Sprintf("%s (code %d)", st.Reason, *st.Code)
+	}
+	return st.Reason
+}
+
+// exitStatus works out why the session ended once its output stream has
+// closed. terminated is the reason recorded by Terminate, if any.
+func (s *Session) exitStatus(readErr error, terminated string) exitStatus {
+	st := exitStatus{Duration: time.Since(s.Created)}
+
+	if terminated != "" {
+		st.Reason = terminated
+		return st
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if s.spec.Main {
+		if readErr != nil && readErr != io.EOF {
+			st.Reason = reasonError
+			st.Message = readErr.Error()
+			return st
+		}
+		return s.mainExitStatus(ctx, st)
+	}
+
+	if info, err := s.reg.cli.ContainerInspect(ctx, s.ContainerID); err == nil && !info.State.Running {
+		st.Reason = reasonContainerStopped
+		return st
+	}
+
+	if readErr != nil && readErr != io.EOF {
+		st.Reason = reasonError
+		st.Message = readErr.Error()
+		return st
+	}
+
+	code, err := docker.WaitExec(ctx, s.reg.cli, s.ExecID)
+	if err != nil {
+		st.Reason = reasonError
+		st.Message = err.Error()
+		return st
+	}
+	st.Code = &code
+	st.Reason = reasonExited
+	return st
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// Kubernetes exec subprotocols, for terminal components written against
+// the kubelet's exec WebSocket. Every frame starts with a channel number:
+// binary frames in v4, and an ASCII digit followed by base64 data in text
+// frames for the base64 variant.
+const (
+	ProtocolK8sV4     = "v4.channel.k8s.io"
+	ProtocolK8sBase64 = "base64.channel.k8s.io"
+)
+
+// Kubernetes stream channels.
+const (
+	k8sStdin byte = iota
+	k8sStdout
+	k8sStderr
+	k8sError
+	k8sResize
+)
+
+// k8sResizeMsg is the payload of the resize channel.
+type k8sResizeMsg struct {
+	Width  uint `json:"Width"`
+	Height uint `json:"Height"`
+}
+
+// k8sStatus is the subset of a metav1.Status written to the error channel
+// when the command ends.
+type k8sStatus struct {
+	Metadata struct{}          `json:"metadata"`
+	Status   string            `json:"status"`
+	Message  string            `json:"message,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Details  *k8sStatusDetails `json:"details,omitempty"`
+}
+
+type k8sStatusDetails struct {
+	Causes []k8sStatusCause `json:"causes"`
+}
+
+type k8sStatusCause struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type k8sCodec struct {
+	base64 bool
+}
+
+func (k k8sCodec) decode(mt int, payload []byte) ([]byte, *controlMsg, error) {
+	ch, data, err := k.unframe(mt, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch ch {
+	case k8sStdin:
+		return data, nil, nil
+	case k8sResize:
+		var msg k8sResizeMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, nil, fmt.Errorf("invalid resize message: %w", err)
+		}
+		return nil, &controlMsg{Type: "resize", Cols: msg.Width, Rows: msg.Height}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported channel %d", ch)
+}
+
+func (k k8sCodec) unframe(mt int, payload []byte) (byte, []byte, error) {
+	if !k.base64 {
+		return payload[0], payload[1:], nil
+	}
+	if mt != websocket.TextMessage || payload[0] < '0' || payload[0] > '9' {
+		return 0, nil, fmt.Errorf("invalid base64 channel frame")
+	}
+	data, err := base64.StdEncoding.DecodeString(string(payload[1:]))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid base64 channel frame: %w", err)
+	}
+	return payload[0] - '0', data, nil
+}
+
+func (k k8sCodec) frame(ch byte, p []byte) (int, []byte) {
+	if k.base64 {
+		b := make([]byte, 1+base64.StdEncoding.EncodedLen(len(p)))
+		b[0] = '0' + ch
+		base64.StdEncoding.Encode(b[1:], p)
+		return websocket.TextMessage, b
+	}
+	b := make([]byte, 1+len(p))
+	b[0] = ch
+	copy(b[1:], p)
+	return websocket.BinaryMessage, b
+}
+
+func (k k8sCodec) data(p []byte) (int, []byte) {
+	return k.frame(k8sStdout, p)
+}
+
+// event reports errors on stderr, since any message on the error channel
+// ends the stream for Kubernetes clients, and the exit status as a Status
+// on the error channel.
+func (k k8sCodec) event(ev serverEvent) (int, []byte, bool) {
+	switch ev.Type {
+	case "error":
+		mt, b := k.frame(k8sStderr, []byte(ev.Message+"\r\n"))
+		return mt, b, true
+	case "exit":
+		b, _ := json.Marshal(k8sExitStatus(ev))
+		mt, b := k.frame(k8sError, b)
+		return mt, b, true
+	}
+	return 0, nil, false
+}
+
+func k8sExitStatus(ev serverEvent) k8sStatus {
+	switch {
+	case ev.ExitCode != nil && *ev.ExitCode == 0:
+		return k8sStatus{Status: "Success"}
+	case ev.ExitCode != nil:
+		return k8sStatus{
+			Status:  "Failure",
+			Message: fmt.Sprintf("command terminated with non-zero exit code: %d", *ev.ExitCode),
+			Reason:  "NonZeroExitCode",
+			Details: &k8sStatusDetails{Causes: []k8sStatusCause{{
+				Reason:  "ExitCode",
+				Message: strconv.Itoa(*ev.ExitCode),
+			}}},
+		}
+	}
+	msg := "session ended: " + ev.Reason
+	if ev.Message != "" {
+		msg += ": " + ev.Message
+	}
+	return k8sStatus{Status: "Failure", Message: msg, Reason: "InternalError"}
+}
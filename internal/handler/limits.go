@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Limits caps the number of concurrent sessions. Zero means unlimited.
+// Re-attaching to or joining an existing session does not count against
+// them; only starting a new shell does.
+type Limits struct {
+	Global       int
+	PerContainer int
+	PerUser      int
+}
+
+// Limit scopes, used in rejection messages and as the metrics label.
+const (
+	limitGlobal    = "global"
+	limitContainer = "container"
+	limitUser      = "user"
+)
+
+var limitScopes = []string{limitGlobal, limitContainer, limitUser}
+
+// limitError is returned by registry.create when starting a session would
+// exceed a limit.
+type limitError struct {
+	scope string
+	max   int
+}
+
+func (e *limitError) Error() string {
+	switch e.scope {
+	case limitContainer:
+		return fmt.Sprintf("too many terminal sessions in this container (limit %d), close another terminal first", e.max)
+	case limitUser:
+		return fmt.Sprintf("too many terminal sessions for this user (limit %d), close another terminal first", e.max)
+	}
+	return fmt.Sprintf("the server has reached its terminal session limit (%d), try again later", e.max)
+}
+
+// slot is a session being created; it counts against the limits until the
+// session is registered.
+type slot struct {
+	containerID string
+	owner       string
+}
+
+// reserve claims a slot for a new session or reports which limit it would
+// exceed. The caller must either register the session with the slot or
+// release the slot if creating the session failed.
+func (r *registry) reserve(containerID, owner string) (*slot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var global, perContainer, perUser int
+	count := func(cid, own string) {
+		global++
+		if cid == containerID {
+			perContainer++
+		}
+		if owner != "" && own == owner {
+			perUser++
+		}
+	}
+	for _, s := range r.sessions {
+		count(s.ContainerID, s.Owner)
+	}
+	for sl := range r.pending {
+		count(sl.containerID, sl.owner)
+	}
+
+	lim := r.cfg.Limits
+	var err *limitError
+	switch {
+	case lim.Global > 0 && global >= lim.Global:
+		err = &limitError{scope: limitGlobal, max: lim.Global}
+	case lim.PerContainer > 0 && perContainer >= lim.PerContainer:
+		err = &limitError{scope: limitContainer, max: lim.PerContainer}
+	case lim.PerUser > 0 && owner != "" && perUser >= lim.PerUser:
+		err = &limitError{scope: limitUser, max: lim.PerUser}
+	}
+	if err != nil {
+		r.rejected[err.scope].Add(1)
+		return nil, err
+	}
+
+	sl := &slot{containerID: containerID, owner: owner}
+	r.pending[sl] = struct{}{}
+	return sl, nil
+}
+
+// register publishes s and frees its slot in one step, so that reserve
+// never counts the session twice.
+func (r *registry) register(sl *slot, s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, sl)
+	r.sessions[s.ID] = s
+}
+
+func (r *registry) release(sl *slot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, sl)
+}
+
+func newRejectionCounters() map[string]*atomic.Int64 {
+	m := make(map[string]*atomic.Int64, len(limitScopes))
+	for _, scope := range limitScopes {
+		m[scope] = new(atomic.Int64)
+	}
+	return m
+}
+
+// metricsHandler exposes session gauges and rejection counters in the
+// Prometheus text format: GET /metrics
+func metricsHandler(reg *registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		reg.mu.Lock()
+		active := len(reg.sessions)
+		reg.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP pty_proxy_sessions_active Terminal sessions currently running.")
+		fmt.Fprintln(w, "# TYPE pty_proxy_sessions_active gauge")
+		fmt.Fprintf(w, "pty_proxy_sessions_active %d\n", active)
+		fmt.Fprintln(w, "# HELP pty_proxy_sessions_rejected_total New terminal sessions rejected by a concurrency limit.")
+		fmt.Fprintln(w, "# TYPE pty_proxy_sessions_rejected_total counter")
+		for _, scope := range limitScopes {
+			fmt.Fprintf(w, "pty_proxy_sessions_rejected_total{limit=%q} %d\n", scope, reg.rejected[scope].Load())
+		}
+	}
+}
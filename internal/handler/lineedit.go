@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/utf8safe"
+)
+
+// lineEditor reconstructs the command lines a user typed from raw terminal
+// input, approximating what a shell's line editor would see: printable
+// characters are appended, backspace/^U/^W edit the line, ^C discards it
+// and Enter completes it. Escape sequences (arrows, function keys) are
+// skipped, so history recall and in-line cursor movement are not reflected.
+type lineEditor struct {
+	line    []rune
+	pending []byte // incomplete UTF-8 sequence
+	esc     escState
+}
+
+type escState int
+
+const (
+	escNone  escState = iota
+	escStart          // after ESC
+	escCSI            // after ESC [
+	escSS3            // after ESC O
+)
+
+// feed consumes input and returns the lines completed by it.
+func (e *lineEditor) feed(p []byte) []string {
+	var done []string
+	data, rest := utf8safe.Split(append(e.pending, p...))
+	e.pending = append([]byte(nil), rest...)
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+
+		switch e.esc {
+		case escStart:
+			switch r {
+			case '[':
+				e.esc = escCSI
+			case 'O':
+				e.esc = escSS3
+			default:
+				e.esc = escNone // ESC <char>, e.g. Alt+key
+			}
+			continue
+		case escCSI:
+			if r >= 0x40 && r <= 0x7e {
+				e.esc = escNone
+			}
+			continue
+		case escSS3:
+			e.esc = escNone
+			continue
+		}
+
+		switch r {
+		case 0x1b:
+			e.esc = escStart
+		case '\r', '\n':
+			if len(e.line) > 0 {
+				done = append(done, string(e.line))
+			}
+			e.line = e.line[:0]
+		case 0x7f, 0x08: // DEL, BS
+			if len(e.line) > 0 {
+				e.line = e.line[:len(e.line)-1]
+			}
+		case 0x15, 0x03: // ^U clears the line, ^C abandons it
+			e.line = e.line[:0]
+		case 0x17: // ^W deletes the previous word
+			i := len(e.line)
+			for i > 0 && unicode.IsSpace(e.line[i-1]) {
+				i--
+			}
+			for i > 0 && !unicode.IsSpace(e.line[i-1]) {
+				i--
+			}
+			e.line = e.line[:i]
+		case '\t':
+			e.line = append(e.line, r)
+		default:
+			if r != utf8.RuneError && !unicode.IsControl(r) {
+				e.line = append(e.line, r)
+			}
+		}
+	}
+	return done
+}
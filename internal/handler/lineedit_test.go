@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{"simple", []string{"ls -la\r"}, []string{"ls -la"}},
+		{"newline", []string{"pwd\n"}, []string{"pwd"}},
+		{"several lines", []string{"cd src\rmake\r"}, []string{"cd src", "make"}},
+		{"empty lines skipped", []string{"\r\r\n"}, nil},
+		{"unfinished", []string{"echo hi"}, nil},
+		{"typed one key at a time", []string{"g", "i", "t", "\r"}, []string{"git"}},
+		{"backspace", []string{"lss\x7f\r"}, []string{"ls"}},
+		{"ctrl-h", []string{"lss\x08\r"}, []string{"ls"}},
+		{"backspace on empty line", []string{"\x7f\x7fls\r"}, []string{"ls"}},
+		{"ctrl-u", []string{"rm -rf /\x15ls\r"}, []string{"ls"}},
+		{"ctrl-c", []string{"sleep 100\x03", "ls\r"}, []string{"ls"}},
+		{"ctrl-w", []string{"git commit  \x17push\r"}, []string{"git push"}},
+		{"ctrl-w on one word", []string{"make\x17\r"}, nil},
+		{"tab kept", []string{"cd sr\t\r"}, []string{"cd sr\t"}},
+		{"arrow keys skipped", []string{"\x1b[Als\x1b[D\x1b[C\r"}, []string{"ls"}},
+		{"CSI with parameters", []string{"a\x1b[1;5Db\r"}, []string{"ab"}},
+		{"SS3 keys skipped", []string{"\x1bOPls\r"}, []string{"ls"}},
+		{"alt key skipped", []string{"\x1bbls\r"}, []string{"ls"}},
+		{"escape split across chunks", []string{"a\x1b", "[", "Db\r"}, []string{"ab"}},
+		{"other control characters dropped", []string{"l\x01s\x1a\r"}, []string{"ls"}},
+		{"unicode", []string{"echo привіт\r"}, []string{"echo привіт"}},
+		{"backspace removes a whole rune", []string{"ї\x7fi\r"}, []string{"i"}},
+		{"rune split across chunks", []string{"echo \xf0\x9f", "\x98\x80\r"}, []string{"echo 😀"}},
+		{"invalid bytes dropped", []string{"a\xffb\r"}, []string{"ab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e lineEditor
+			var got []string
+			for _, c := range tt.chunks {
+				got = append(got, e.feed([]byte(c))...)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
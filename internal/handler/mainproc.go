@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// attachMain attaches the session to the container's main process, like
+// "docker attach", replaying the output it has logged so far. Input only
+// reaches the process if the container was started with stdin open.
+func (s *Session) attachMain(ctx context.Context) error {
+	info, err := s.reg.cli.ContainerInspect(ctx, s.ContainerID)
+	if err != nil {
+		return fmt.Errorf("container inspect error: %w", err)
+	}
+	if !info.State.Running {
+		return fmt.Errorf("container %s is not running", s.ContainerID)
+	}
+
+	hijack, err := s.reg.cli.ContainerAttach(ctx, s.ContainerID, container.AttachOptions{
+		Stream: true,
+		Stdin:  info.Config.OpenStdin,
+		Stdout: true,
+		Stderr: true,
+		Logs:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("container attach error: %w", err)
+	}
+	s.hijack = hijack
+	s.out = hijack.Reader
+	if !info.Config.Tty {
+		// Without a TTY stdout and stderr are multiplexed on the stream;
+		// the terminal shows them interleaved.
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(pw, pw, hijack.Reader)
+			pw.CloseWithError(err)
+		}()
+		s.out = pr
+	}
+	s.spec.Cmd = strings.Join(append([]string{info.Path}, info.Args...), " ")
+	return nil
+}
+
+// mainExitStatus reports the container's exit code once its main process
+// has ended the stream.
+func (s *Session) mainExitStatus(ctx context.Context, st exitStatus) exitStatus {
+	waitC, errC := s.reg.cli.ContainerWait(ctx, s.ContainerID, container.WaitConditionNotRunning)
+	select {
+	case res := <-waitC:
+		code := int(res.StatusCode)
+		st.Code = &code
+		st.Reason = reasonContainerStopped
+	case err := <-errC:
+		st.Reason = reasonError
+		st.Message = err.Error()
+	}
+	return st
+}
@@ -0,0 +1,408 @@
+package handler
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// muxHeaderLen is the size of the big-endian channel ID that prefixes every
+// binary frame on a multiplexed socket.
+const muxHeaderLen = 2
+
+// muxMsg is a client → server message on a multiplexed socket. "open"
+// takes the same options as the /attach query string.
+type muxMsg struct {
+	controlMsg
+	Channel  int      `json:"channel"`
+	Session  string   `json:"session,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Role     string   `json:"role,omitempty"`
+	Window   int      `json:"window,omitempty"`
+	Encoding string   `json:"encoding,omitempty"`
+	Cmd      string   `json:"cmd,omitempty"`
+	User     string   `json:"user,omitempty"`
+	Env      []string `json:"env,omitempty"`
+	Cwd      string   `json:"cwd,omitempty"`
+	TrackCwd *bool    `json:"trackCwd,omitempty"`
+}
+
+// query returns the open options in the form /attach receives them.
+func (m *muxMsg) query() url.Values {
+	q := url.Values{}
+	set := func(key, v string) {
+		if v != "" {
+			q.Set(key, v)
+		}
+	}
+	set("role", m.Role)
+	set("mode", m.Mode)
+	set("encoding", m.Encoding)
+	set("cmd", m.Cmd)
+	set("user", m.User)
+	set("cwd", m.Cwd)
+	if m.Window != 0 {
+		q.Set("window", strconv.Itoa(m.Window))
+	}
+	if m.TrackCwd != nil {
+		q.Set("trackCwd", strconv.FormatBool(*m.TrackCwd))
+	}
+	q["env"] = m.Env
+	return q
+}
+
+// muxCodec frames the output of one channel: binary frames are prefixed
+// with the channel ID and events carry it in "channel". Input is decoded as
+// pty.v1 once the socket has stripped the prefix.
+type muxCodec struct {
+	v1Codec
+	channel uint16
+}
+
+func (c muxCodec) data(p []byte) (int, []byte) {
+	b := make([]byte, muxHeaderLen+len(p))
+	binary.BigEndian.PutUint16(b, c.channel)
+	copy(b[muxHeaderLen:], p)
+	return websocket.BinaryMessage, b
+}
+
+func (c muxCodec) event(ev serverEvent) (int, []byte, bool) {
+	ev.Channel = int(c.channel)
+	return c.v1Codec.event(ev)
+}
+
+// muxSocket is one multiplexed WebSocket and the channels open on it.
+type muxSocket struct {
+	reg         *registry
+	ws          *websocket.Conn
+	containerID string
+	owner       string
+	addr        string
+
+	wmu sync.Mutex // serialises writes from the channels' writers
+
+	mu       sync.Mutex
+	channels map[uint16]*muxChannel
+}
+
+// muxChannel is a session attached on one channel.
+type muxChannel struct {
+	sess *Session
+	sub  *subscriber
+	conn *muxConn
+}
+
+// detach unsubscribes the channel from its session, which keeps running
+// until the grace period ends, like a dropped /attach socket.
+func (ch *muxChannel) detach() {
+	ch.sess.Leave(ch.sub)
+	ch.sub.close()
+}
+
+// muxConn is the conn a channel's subscriber writes to. Writes share the
+// socket; closing it ends only the channel.
+type muxConn struct {
+	m  *muxSocket
+	id uint16
+}
+
+func (c *muxConn) WriteMessage(mt int, data []byte) error {
+	return c.m.write(mt, data)
+}
+
+// WriteControl turns the close frame that ends a session into a "closed"
+// event for the channel. Other control frames go to the socket.
+func (c *muxConn) WriteControl(mt int, data []byte, deadline time.Time) error {
+	if mt != websocket.CloseMessage {
+		return c.m.ws.WriteControl(mt, data, deadline)
+	}
+	ev := serverEvent{Type: "closed", Channel: int(c.id), Code: websocket.CloseNormalClosure}
+	if len(data) >= 2 {
+		ev.Code = int(binary.BigEndian.Uint16(data))
+		ev.Message = string(data[2:])
+	}
+	return c.m.sendEvent(ev)
+}
+
+// SetWriteDeadline is a no-op: write sets the deadline under the socket's
+// write lock.
+func (c *muxConn) SetWriteDeadline(time.Time) error { return nil }
+
+// Close detaches the channel. The subscriber may close it while the
+// session's lock is held, so the detach runs on its own goroutine.
+func (c *muxConn) Close() error {
+	go func() {
+		if ch := c.m.remove(c.id, c); ch != nil {
+			ch.detach()
+		}
+	}()
+	return nil
+}
+
+// muxHandler serves /attach/mux: one WebSocket carrying any number of
+// terminals in a container, each on its own channel.
+func muxHandler(reg *registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID := r.URL.Query().Get("id")
+		if containerID == "" {
+			http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+			return
+		}
+
+		up := upgrader
+		up.Subprotocols = nil
+		up.EnableCompression = reg.cfg.Compression
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[mux] websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		m := &muxSocket{
+			reg:         reg,
+			ws:          ws,
+			containerID: containerID,
+			owner:       r.Header.Get(reg.cfg.UserHeader),
+			addr:        r.RemoteAddr,
+			channels:    make(map[uint16]*muxChannel),
+		}
+		defer m.closeAll()
+
+		alive := func() {
+			if reg.cfg.PongWait > 0 {
+				_ = ws.SetReadDeadline(time.Now().Add(reg.cfg.PongWait))
+			}
+		}
+		alive()
+		ws.SetPongHandler(func(string) error {
+			alive()
+			return nil
+		})
+		// The socket is pinged once for all channels.
+		if reg.cfg.PingInterval > 0 {
+			go m.pinger(ctx)
+		}
+
+		log.Printf("[mux] %s connected to container %s", r.RemoteAddr, containerID)
+		for {
+			mt, payload, readErr := ws.ReadMessage()
+			if readErr != nil {
+				if websocket.IsUnexpectedCloseError(readErr, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.Printf("[mux] read from websocket failed: %v", readErr)
+				}
+				break
+			}
+			alive()
+
+			if mt == websocket.BinaryMessage {
+				m.input(payload)
+				continue
+			}
+			var msg muxMsg
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				_ = m.sendEvent(errorEvent("invalid control message: %v", err))
+				continue
+			}
+			m.handle(ctx, &msg)
+		}
+		log.Printf("[mux] %s disconnected from container %s", r.RemoteAddr, containerID)
+	}
+}
+
+func channelError(id uint16, format string, args ...any) serverEvent {
+	ev := errorEvent(format, args...)
+	ev.Channel = int(id)
+	return ev
+}
+
+// handle applies a control message from the client.
+func (m *muxSocket) handle(ctx context.Context, msg *muxMsg) {
+	if msg.Type == "ping" {
+		_ = m.sendEvent(serverEvent{Type: "pong", ID: msg.ID, Channel: msg.Channel})
+		return
+	}
+	if msg.Channel <= 0 || msg.Channel > math.MaxUint16 {
+		_ = m.sendEvent(errorEvent("invalid channel %d", msg.Channel))
+		return
+	}
+	id := uint16(msg.Channel)
+
+	switch msg.Type {
+	case "open":
+		m.open(id, msg)
+
+	case "close":
+		if ch := m.remove(id, nil); ch != nil {
+			ch.detach()
+			_ = m.sendEvent(serverEvent{Type: "closed", Channel: int(id), Code: websocket.CloseNormalClosure})
+		}
+
+	default:
+		ch := m.channel(id)
+		if ch == nil {
+			_ = m.sendEvent(channelError(id, "channel %d is not open", id))
+			return
+		}
+		ch.sub.received(0)
+		handleControl(ctx, ch.sess, ch.sub, &msg.controlMsg)
+	}
+}
+
+// open attaches a new or existing session on channel id.
+func (m *muxSocket) open(id uint16, msg *muxMsg) {
+	if m.channel(id) != nil {
+		_ = m.sendEvent(channelError(id, "channel %d is already open", id))
+		return
+	}
+
+	q := msg.query()
+	opts, err := parseClientOptions(q)
+	if err != nil {
+		_ = m.sendEvent(channelError(id, "%v", err))
+		return
+	}
+	var spec execSpec
+	if msg.Session == "" {
+		if spec, err = m.reg.cfg.Shell.resolve(q); err != nil {
+			_ = m.sendEvent(channelError(id, "%v", err))
+			return
+		}
+	}
+
+	sess, err := m.reg.acquire(m.containerID, msg.Session, m.owner, spec)
+	if err != nil {
+		log.Printf("[mux] %v", err)
+		_ = m.sendEvent(channelError(id, "%v", err))
+		return
+	}
+	role := opts.role
+	if msg.Session == "" {
+		// Whoever starts the shell owns it; role only applies when joining.
+		role = RoleOwner
+	}
+	log.Printf("[mux] %s opened channel %d on session %s (exec %s) in container %s as %s", m.addr, id, sess.ID, sess.ExecID, m.containerID, role)
+
+	so := m.reg.cfg.subscriberOptions(role, opts.window, opts.utf8)
+	so.pingInterval = 0
+	conn := &muxConn{m: m, id: id}
+	ch := &muxChannel{
+		sess: sess,
+		sub:  newSubscriber(conn, muxCodec{channel: id}, role, m.addr, so),
+		conn: conn,
+	}
+	// Register before joining so that a session ending right away finds
+	// the channel to remove.
+	m.mu.Lock()
+	m.channels[id] = ch
+	m.mu.Unlock()
+
+	ch.sub.sendEvent(sessionEvent(sess.ID, role))
+	if err := sess.Join(ch.sub); err != nil {
+		m.remove(id, conn)
+		ch.sub.close()
+		_ = m.sendEvent(channelError(id, "%v", err))
+	}
+}
+
+// input writes a binary frame's payload to its channel's session.
+func (m *muxSocket) input(payload []byte) {
+	if len(payload) < muxHeaderLen {
+		_ = m.sendEvent(errorEvent("binary frame without channel ID"))
+		return
+	}
+	id := binary.BigEndian.Uint16(payload)
+	ch := m.channel(id)
+	if ch == nil {
+		_ = m.sendEvent(channelError(id, "channel %d is not open", id))
+		return
+	}
+	data := payload[muxHeaderLen:]
+	ch.sub.received(0)
+	if len(data) == 0 || !ch.sub.role.canWrite() {
+		return
+	}
+	ch.sub.received(len(data))
+	if m.reg.audit != nil {
+		m.reg.audit.input(ch.sess, ch.sub, data)
+	}
+	if _, err := ch.sess.Write(data); err != nil {
+		log.Printf("[mux] write to docker failed: %v", err)
+		if gone := m.remove(id, ch.conn); gone != nil {
+			gone.detach()
+		}
+		_ = m.sendEvent(channelError(id, "write failed: %v", err))
+	}
+}
+
+func (m *muxSocket) channel(id uint16) *muxChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.channels[id]
+}
+
+// remove unregisters channel id and returns it. If conn is not nil the
+// channel is only removed while it still belongs to conn, so that a late
+// close of an old channel cannot remove a new one reusing its ID.
+func (m *muxSocket) remove(id uint16, conn *muxConn) *muxChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := m.channels[id]
+	if ch == nil || (conn != nil && ch.conn != conn) {
+		return nil
+	}
+	delete(m.channels, id)
+	return ch
+}
+
+// closeAll detaches every channel when the socket goes away.
+func (m *muxSocket) closeAll() {
+	m.mu.Lock()
+	channels := m.channels
+	m.channels = make(map[uint16]*muxChannel)
+	m.mu.Unlock()
+	for _, ch := range channels {
+		ch.detach()
+	}
+}
+
+func (m *muxSocket) write(mt int, data []byte) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	_ = m.ws.SetWriteDeadline(time.Now().Add(writeDeadline))
+	return m.ws.WriteMessage(mt, data)
+}
+
+func (m *muxSocket) sendEvent(ev serverEvent) error {
+	b, _ := json.Marshal(ev)
+	return m.write(websocket.TextMessage, b)
+}
+
+func (m *muxSocket) pinger(ctx context.Context) {
+	t := time.NewTicker(m.reg.cfg.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeDeadline)); err != nil {
+				log.Printf("[mux] ping to %s failed: %v", m.addr, err)
+				_ = m.ws.Close()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
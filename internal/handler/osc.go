@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxOSCLen bounds an OSC sequence; longer ones are discarded.
+const maxOSCLen = 4096
+
+// oscParser watches terminal output for OSC (Operating System Command)
+// sequences that report the window title (OSC 0 and 2) and the working
+// directory (OSC 7, "file://host/path"). It keeps state across chunks and
+// never modifies the stream.
+type oscParser struct {
+	state oscState
+	buf   []byte
+}
+
+type oscState int
+
+const (
+	oscNone   oscState = iota
+	oscEsc             // after ESC
+	oscBody            // inside ESC ] ...
+	oscBodyST          // inside the body, after ESC (possible ST)
+)
+
+type oscUpdate struct {
+	title string
+	cwd   string
+}
+
+// feed scans p and returns the title and cwd updates it completes, in order.
+func (o *oscParser) feed(p []byte) []oscUpdate {
+	var out []oscUpdate
+	for _, b := range p {
+		switch o.state {
+		case oscNone:
+			if b == 0x1b {
+				o.state = oscEsc
+			}
+		case oscEsc:
+			if b == ']' {
+				o.state = oscBody
+				o.buf = o.buf[:0]
+			} else if b != 0x1b {
+				o.state = oscNone
+			}
+		case oscBody:
+			switch {
+			case b == 0x07: // BEL terminates
+				out = o.finish(out)
+			case b == 0x1b:
+				o.state = oscBodyST
+			case len(o.buf) >= maxOSCLen:
+				o.state = oscNone
+			default:
+				o.buf = append(o.buf, b)
+			}
+		case oscBodyST:
+			if b == '\\' { // ESC \ terminates
+				out = o.finish(out)
+			} else {
+				o.state = oscNone
+			}
+		}
+	}
+	return out
+}
+
+func (o *oscParser) finish(out []oscUpdate) []oscUpdate {
+	o.state = oscNone
+	ps, pt, ok := strings.Cut(string(o.buf), ";")
+	if !ok {
+		return out
+	}
+	switch ps {
+	case "0", "2":
+		return append(out, oscUpdate{title: pt})
+	case "7":
+		if cwd := parseOSC7(pt); cwd != "" {
+			return append(out, oscUpdate{cwd: cwd})
+		}
+	}
+	return out
+}
+
+// parseOSC7 extracts the path from "file://host/path". Shell hooks often
+// print $PWD without percent-encoding it, so an undecodable path is used
+// as is.
+func parseOSC7(s string) string {
+	rest, ok := strings.CutPrefix(s, "file://")
+	if !ok {
+		return ""
+	}
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return ""
+	}
+	p := rest[i:]
+	if dec, err := url.PathUnescape(p); err == nil {
+		return dec
+	}
+	return p
+}
+
+// cwdHookEnv returns the variables that make shell report its working
+// directory with OSC 7 at every prompt. zsh can only do this from its rc
+// files, so it gets none.
+func cwdHookEnv(shell string) []string {
+	switch path.Base(shell) {
+	case "bash":
+		return []string{`PROMPT_COMMAND=printf '\033]7;file://localhost%s\007' "$PWD"`}
+	case "sh", "ash", "dash":
+		// POSIX shells parameter-expand PS1 before every prompt.
+		return []string{"PS1=\x1b]7;file://localhost$PWD\x07$ "}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOSCParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []oscUpdate
+	}{
+		{"none", []string{"plain output\r\n"}, nil},
+		{"title with BEL", []string{"\x1b]0;vim main.go\x07"}, []oscUpdate{{title: "vim main.go"}}},
+		{"title with ST", []string{"\x1b]2;build\x1b\\"}, []oscUpdate{{title: "build"}}},
+		{"empty title", []string{"\x1b]2;\x07"}, []oscUpdate{{title: ""}}},
+		{"cwd", []string{"\x1b]7;file://host/workspace/src\x07"}, []oscUpdate{{cwd: "/workspace/src"}}},
+		{"cwd percent-encoded", []string{"\x1b]7;file://host/work%20space\x07"}, []oscUpdate{{cwd: "/work space"}}},
+		{"cwd not encoded", []string{"\x1b]7;file://host/100%done\x07"}, []oscUpdate{{cwd: "/100%done"}}},
+		{"cwd without path", []string{"\x1b]7;file://host\x07"}, nil},
+		{"cwd not a file URL", []string{"\x1b]7;/workspace\x07"}, nil},
+		{"other OSC", []string{"\x1b]8;;https://example.com\x07"}, nil},
+		{"no parameter", []string{"\x1b]0\x07"}, nil},
+		{
+			"split across chunks",
+			[]string{"out\x1b", "]0;ti", "tle\x1b", "\\more"},
+			[]oscUpdate{{title: "title"}},
+		},
+		{
+			"several in one chunk",
+			[]string{"\x1b]7;file://h/a\x07$ \x1b]2;b\x07"},
+			[]oscUpdate{{cwd: "/a"}, {title: "b"}},
+		},
+		{"CSI is ignored", []string{"\x1b[31mred\x1b[0m"}, nil},
+		{"repeated ESC", []string{"\x1b\x1b]0;t\x07"}, []oscUpdate{{title: "t"}}},
+		{"ESC inside body aborts", []string{"\x1b]0;a\x1bxb\x07"}, nil},
+		{"too long is dropped", []string{"\x1b]0;" + string(make([]byte, maxOSCLen)) + "\x07"}, nil},
+		{
+			"recovers after a dropped sequence",
+			[]string{"\x1b]0;" + string(make([]byte, maxOSCLen)) + "\x07", "\x1b]0;ok\x07"},
+			[]oscUpdate{{title: "ok"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o oscParser
+			var got []oscUpdate
+			for _, c := range tt.chunks {
+				got = append(got, o.feed([]byte(c))...)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("updates = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
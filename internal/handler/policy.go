@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
+)
+
+// ShellPolicy is the server-side allowlist for what a client may request
+// when it starts a terminal session.
+type ShellPolicy struct {
+	// DefaultCommand runs when the client does not ask for one.
+	DefaultCommand string
+	// Commands lists the shells a client may select with "cmd".
+	Commands []string
+	// Users lists the users a client may select with "user". "*" allows
+	// any user; an empty list only allows the container's default user.
+	Users []string
+	// EnvKeys lists the variables a client may set with "env=KEY=VALUE".
+	EnvKeys []string
+	// WorkdirRoot is the default working directory; "cwd" must resolve to
+	// it or a directory below it.
+	WorkdirRoot string
+	// TrackCwd injects a prompt hook that reports the working directory
+	// unless the client overrides it with "trackCwd".
+	TrackCwd bool
+	// AllowMain lets a client attach to the container's main process with
+	// "mode=main". Off by default: such a client can interrupt PID 1.
+	AllowMain bool
+}
+
+// execSpec is a resolved, policy-checked description of the exec to start.
+type execSpec struct {
+	Cmd        string
+	User       string
+	Env        []string
+	WorkingDir string
+	Root       string
+	// Main attaches to the container's main process instead of starting
+	// Cmd; the other fields are then unused.
+	Main bool
+}
+
+// resolve validates the mode, cmd, user, env and cwd query parameters
+// against the policy and fills in defaults for the ones that are absent.
+func (p ShellPolicy) resolve(q url.Values) (execSpec, error) {
+	switch mode := q.Get("mode"); mode {
+	case "", "exec":
+	case "main":
+		if !p.AllowMain {
+			return execSpec{}, fmt.Errorf("attaching to the main process is not allowed")
+		}
+		return execSpec{Main: true}, nil
+	default:
+		return execSpec{}, fmt.Errorf(`invalid mode %q, must be "exec" or "main"`, mode)
+	}
+
+	spec := execSpec{
+		Cmd:        p.DefaultCommand,
+		Env:        []string{"TERM=xterm"},
+		WorkingDir: p.WorkdirRoot,
+		Root:       p.WorkdirRoot,
+	}
+
+	if cmd := q.Get("cmd"); cmd != "" {
+		if !slices.Contains(p.Commands, cmd) {
+			return spec, fmt.Errorf("command %q is not allowed", cmd)
+		}
+		spec.Cmd = cmd
+	}
+
+	if user := q.Get("user"); user != "" {
+		if !slices.Contains(p.Users, "*") && !slices.Contains(p.Users, user) {
+			return spec, fmt.Errorf("user %q is not allowed", user)
+		}
+		spec.User = user
+	}
+
+	for _, kv := range q["env"] {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || !workspace.ValidEnvKey(key) {
+			return spec, fmt.Errorf("invalid env %q, expected KEY=VALUE", kv)
+		}
+		if !slices.Contains(p.EnvKeys, key) {
+			return spec, fmt.Errorf("env %q is not allowed", key)
+		}
+		spec.Env = append(spec.Env, kv)
+	}
+
+	if cwd := q.Get("cwd"); cwd != "" {
+		if strings.ContainsRune(cwd, 0) {
+			return spec, fmt.Errorf("cwd must not contain null bytes")
+		}
+		dir := cwd
+		if !path.IsAbs(dir) {
+			dir = path.Join(p.WorkdirRoot, dir)
+		}
+		dir = path.Clean(dir)
+		if dir != p.WorkdirRoot && !strings.HasPrefix(dir, strings.TrimSuffix(p.WorkdirRoot, "/")+"/") {
+			return spec, fmt.Errorf("cwd must be inside %s", p.WorkdirRoot)
+		}
+		spec.WorkingDir = dir
+	}
+
+	trackCwd := p.TrackCwd
+	if v := q.Get("trackCwd"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return spec, fmt.Errorf("invalid trackCwd %q", v)
+		}
+		trackCwd = b
+	}
+	if trackCwd {
+		spec.Env = append(spec.Env, cwdHookEnv(spec.Cmd)...)
+	}
+
+	return spec, nil
+}
+
+// argv wraps the requested shell so that a bad request degrades instead of
+// failing: a missing working directory leaves the shell in Root, and
+// a shell the image does not ship falls back to /bin/sh.
+func (e execSpec) argv() []string {
+	return []string{
+		"/bin/sh", "-c",
+		`cd "$1" 2>/dev/null || echo "cannot cd to $1" >&2
+if command -v "$2" >/dev/null 2>&1; then exec "$2"; fi
+echo "$2 not found, falling back to /bin/sh" >&2
+exec /bin/sh`,
+		"sh", e.WorkingDir, e.Cmd,
+	}
+}
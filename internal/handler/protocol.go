@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolV1 is the WebSocket subprotocol for the structured attach
+// protocol. Text frames carry JSON control messages in both directions and
+// binary frames carry raw terminal bytes. Clients that do not negotiate a
+// subprotocol get the legacy framing, where any payload that parses as a
+// resize message is treated as one.
+const ProtocolV1 = "pty.v1"
+
+// controlMsg is a client → server control message.
+type controlMsg struct {
+	Type   string `json:"type"`
+	Cols   uint   `json:"cols,omitempty"`
+	Rows   uint   `json:"rows,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	Target string `json:"target,omitempty"`
+	Bytes  int    `json:"bytes,omitempty"`
+	ID     string `json:"id,omitempty"`
+}
+
+// serverEvent is a server → client control message.
+type serverEvent struct {
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	Role       Role   `json:"role,omitempty"`
+	Message    string `json:"message,omitempty"`
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Channel    int    `json:"channel,omitempty"`
+	Code       int    `json:"code,omitempty"`
+}
+
+func sessionEvent(id string, role Role) serverEvent {
+	return serverEvent{Type: "session", ID: id, Role: role}
+}
+
+func errorEvent(format string, args ...any) serverEvent {
+	return serverEvent{Type: "error", Message: fmt.Sprintf(format, args...)}
+}
+
+// codec maps between WebSocket messages and terminal data / control
+// messages for one negotiated subprotocol.
+type codec interface {
+	// decode returns either stdin bytes or a control message.
+	decode(mt int, payload []byte) ([]byte, *controlMsg, error)
+	// data frames terminal output.
+	data(p []byte) (int, []byte)
+	// event frames a server event. ok is false if the protocol has no way
+	// to carry it, in which case the event is not sent.
+	event(ev serverEvent) (mt int, payload []byte, ok bool)
+}
+
+// codecFor returns the codec for the negotiated subprotocol. text selects
+// text frames for terminal output where the protocol allows it; announce
+// makes the legacy framing send the session message.
+func codecFor(subprotocol string, text, announce bool) codec {
+	switch subprotocol {
+	case ProtocolV1:
+		return v1Codec{}
+	case ProtocolK8sV4:
+		return k8sCodec{}
+	case ProtocolK8sBase64:
+		return k8sCodec{base64: true}
+	}
+	return legacyCodec{text: text, announce: announce}
+}
+
+// legacyCodec is the original framing. With text set, output is sent as
+// text frames, which browsers hand to the page as strings. Legacy clients
+// such as xterm's AttachAddon print every text frame, so the session
+// message is only sent to those that ask for it with announce.
+type legacyCodec struct {
+	text     bool
+	announce bool
+}
+
+func (legacyCodec) decode(_ int, payload []byte) ([]byte, *controlMsg, error) {
+	if len(payload) > 0 && payload[0] == '{' {
+		var msg controlMsg
+		if json.Unmarshal(payload, &msg) == nil && msg.Type == "resize" {
+			return nil, &msg, nil
+		}
+	}
+	return payload, nil, nil
+}
+
+func (l legacyCodec) data(p []byte) (int, []byte) {
+	if l.text {
+		return websocket.TextMessage, p
+	}
+	return websocket.BinaryMessage, p
+}
+
+func (l legacyCodec) event(ev serverEvent) (int, []byte, bool) {
+	switch ev.Type {
+	case "session":
+		if !l.announce {
+			return 0, nil, false
+		}
+		b, _ := json.Marshal(ev)
+		return websocket.TextMessage, b, true
+	case "error":
+		return websocket.TextMessage, []byte(ev.Message), true
+	}
+	return 0, nil, false
+}
+
+type v1Codec struct{}
+
+func (v1Codec) decode(mt int, payload []byte) ([]byte, *controlMsg, error) {
+	if mt == websocket.BinaryMessage {
+		return payload, nil, nil
+	}
+	var msg controlMsg
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, nil, fmt.Errorf("invalid control message: %w", err)
+	}
+	return nil, &msg, nil
+}
+
+func (v1Codec) data(p []byte) (int, []byte) {
+	return websocket.BinaryMessage, p
+}
+
+func (v1Codec) event(ev serverEvent) (int, []byte, bool) {
+	b, _ := json.Marshal(ev)
+	return websocket.TextMessage, b, true
+}
+
+// writeEvent sends ev directly on ws. It is only used before a subscriber
+// owns the socket's write side.
+func writeEvent(ws *websocket.Conn, c codec, ev serverEvent) {
+	mt, payload, ok := c.event(ev)
+	if !ok {
+		return
+	}
+	_ = ws.SetWriteDeadline(time.Now().Add(writeDeadline))
+	_ = ws.WriteMessage(mt, payload)
+}
+
+// ttySignals are the signals a terminal can deliver by itself, via the line
+// discipline, when the matching control character is typed.
+var ttySignals = map[string]byte{
+	"SIGINT":  0x03, // ^C
+	"SIGQUIT": 0x1c, // ^\
+	"SIGTSTP": 0x1a, // ^Z
+}
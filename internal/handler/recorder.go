@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/utf8safe"
+)
+
+// recorder writes a session's terminal output in asciinema's asciicast v2
+// format: a JSON header line followed by one [time, code, data] event per
+// line. See https://docs.asciinema.org/manual/asciicast/v2/.
+type recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	pending []byte // incomplete UTF-8 sequence carried to the next event
+}
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint              `json:"width"`
+	Height    uint              `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// newRecorder creates <dir>/<containerID>/<start>-<sessionID>.cast.
+func newRecorder(dir, containerID, sessionID string, cols, rows uint, env map[string]string) (*recorder, error) {
+	if !validRecordingSegment(containerID) {
+		return nil, fmt.Errorf("container id %q cannot be used as a recording directory", containerID)
+	}
+	cdir := filepath.Join(dir, containerID)
+	if err := os.MkdirAll(cdir, 0o755); err != nil {
+		return nil, fmt.Errorf("create recording dir: %w", err)
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s%s", now.UTC().Format("20060102T150405Z"), sessionID, castExt)
+	f, err := os.OpenFile(filepath.Join(cdir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+
+	hdr, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: now.Unix(),
+		Title:     containerID,
+		Env:       env,
+	})
+	if _, err := f.Write(append(hdr, '\n')); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write recording header: %w", err)
+	}
+	return &recorder{f: f, start: now}, nil
+}
+
+// Output records data printed by the terminal.
+func (r *recorder) Output(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.pending, p...)
+	data, rest := utf8safe.Split(data)
+	r.pending = append([]byte(nil), rest...)
+	if len(data) > 0 {
+		r.event("o", string(data))
+	}
+}
+
+// Resize records a terminal size change.
+func (r *recorder) Resize(cols, rows uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event writes one line; r.mu must be held.
+func (r *recorder) event(code, data string) {
+	if r.f == nil {
+		return
+	}
+	line, _ := json.Marshal([]any{time.Since(r.start).Seconds(), code, data})
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		log.Printf("[recorder] write %s failed, recording stopped: %v", r.f.Name(), err)
+		_ = r.f.Close()
+		r.f = nil
+	}
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const castExt = ".cast"
+
+// Container IDs and names, and the recording file names we generate, are
+// restricted to this set so they are safe to use as path segments.
+var recordingSegmentRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func validRecordingSegment(s string) bool {
+	return recordingSegmentRe.MatchString(s) && !strings.Contains(s, "..")
+}
+
+type recordingInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// recordingsHandler lists the recordings of a container, newest first.
+func recordingsHandler(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if dir == "" {
+			http.Error(w, "recording is disabled", http.StatusNotFound)
+			return
+		}
+
+		containerID := r.URL.Query().Get("id")
+		if containerID == "" {
+			http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+			return
+		}
+		if !validRecordingSegment(containerID) {
+			http.Error(w, `invalid "id" query parameter`, http.StatusBadRequest)
+			return
+		}
+
+		entries, err := os.ReadDir(filepath.Join(dir, containerID))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[recordings] list error for container %s: %v", containerID, err)
+			http.Error(w, "failed to list recordings", http.StatusInternalServerError)
+			return
+		}
+
+		list := make([]recordingInfo, 0, len(entries))
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), castExt) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			list = append(list, recordingInfo{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			log.Printf("[recordings] encode error: %v", err)
+		}
+	}
+}
+
+// recordingDownloadHandler serves a single .cast file.
+func recordingDownloadHandler(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if dir == "" {
+			http.Error(w, "recording is disabled", http.StatusNotFound)
+			return
+		}
+
+		containerID := r.URL.Query().Get("id")
+		name := r.URL.Query().Get("name")
+		if containerID == "" || name == "" {
+			http.Error(w, `missing "id" or "name" query parameter`, http.StatusBadRequest)
+			return
+		}
+		if !validRecordingSegment(containerID) || !validRecordingSegment(name) || !strings.HasSuffix(name, castExt) {
+			http.Error(w, "invalid recording", http.StatusBadRequest)
+			return
+		}
+
+		f, err := os.Open(filepath.Join(dir, containerID, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "recording not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "failed to open recording", http.StatusInternalServerError)
+			}
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "failed to open recording", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	}
+}
@@ -0,0 +1,44 @@
+package handler
+
+// ringBuffer keeps the last len(buf) bytes written to it. It is used as a
+// per-session scrollback so that a re-attaching client sees the output it
+// missed while disconnected.
+type ringBuffer struct {
+	buf  []byte
+	pos  int // index the next byte is written to
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, size)}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	n, size := len(p), len(r.buf)
+	if size == 0 {
+		return n, nil
+	}
+	if n >= size {
+		copy(r.buf, p[n-size:])
+		r.pos = 0
+		r.full = true
+		return n, nil
+	}
+	c := copy(r.buf[r.pos:], p)
+	copy(r.buf, p[c:])
+	if r.pos+n >= size {
+		r.full = true
+	}
+	r.pos = (r.pos + n) % size
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered data, oldest byte first.
+func (r *ringBuffer) Bytes() []byte {
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.pos]...)
+	}
+	out := make([]byte, 0, len(r.buf))
+	out = append(out, r.buf[r.pos:]...)
+	return append(out, r.buf[:r.pos]...)
+}
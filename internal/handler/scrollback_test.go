@@ -0,0 +1,47 @@
+package handler
+
+import "testing"
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"empty", 4, nil, ""},
+		{"partial", 4, []string{"ab"}, "ab"},
+		{"exactly full", 4, []string{"abcd"}, "abcd"},
+		{"fills in pieces", 4, []string{"ab", "cd"}, "abcd"},
+		{"wraps", 4, []string{"abc", "de"}, "bcde"},
+		{"wraps twice", 4, []string{"abc", "def", "gh"}, "efgh"},
+		{"write larger than buffer", 4, []string{"abcdefg"}, "defg"},
+		{"large write after wrap", 4, []string{"ab", "cdefgh"}, "efgh"},
+		{"single bytes", 3, []string{"a", "b", "c", "d"}, "bcd"},
+		{"empty writes", 3, []string{"", "ab", ""}, "ab"},
+		{"zero size", 0, []string{"abc"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRingBuffer(tt.size)
+			for _, w := range tt.writes {
+				if n, err := r.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := string(r.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingBufferBytesIsACopy(t *testing.T) {
+	r := newRingBuffer(4)
+	r.Write([]byte("abcd"))
+	b := r.Bytes()
+	b[0] = 'x'
+	if got := string(r.Bytes()); got != "abcd" {
+		t.Errorf("Bytes() = %q after modifying a previous result", got)
+	}
+}
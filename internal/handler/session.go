@@ -0,0 +1,500 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+)
+
+// Session is a running interactive exec that outlives the WebSocket it was
+// created from. A client that loses its connection can re-bind to the same
+// exec with /attach?id=...&session=... until the grace period expires.
+// Several clients may be attached at once: output fans out to all of them
+// and input from every client allowed to write is merged into stdin.
+type Session struct {
+	ID          string
+	ContainerID string
+	ExecID      string
+	Created     time.Time
+	// Owner is the authenticated user who started the session, if known.
+	Owner string
+
+	spec   execSpec
+	hijack types.HijackedResponse
+	out    io.Reader // terminal output, demultiplexed if the stream is not a TTY
+	reg    *registry
+
+	mu         sync.Mutex
+	scrollback *ringBuffer
+	osc        oscParser
+	cwd        string
+	title      string
+	rec        *recorder
+	clients    map[*subscriber]struct{}
+	reaper     *time.Timer
+	closed     bool
+	terminated string
+	done       chan struct{}
+	credit     chan struct{} // signalled when a client may have window again
+	writeMu    sync.Mutex
+
+	lastActivity atomic.Int64 // unix nanoseconds of the last input or output
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+}
+
+type registry struct {
+	cli   *client.Client
+	cfg   Config
+	audit *auditLog // nil when auditing is disabled
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	pending  map[*slot]struct{}
+	rejected map[string]*atomic.Int64 // by limit scope
+}
+
+func newRegistry(cli *client.Client, cfg Config) *registry {
+	r := &registry{
+		cli:      cli,
+		cfg:      cfg,
+		sessions: make(map[string]*Session),
+		pending:  make(map[*slot]struct{}),
+		rejected: newRejectionCounters(),
+	}
+	if cfg.AuditLogPath != "" {
+		audit, err := openAuditLog(cfg.AuditLogPath, int64(cfg.AuditMaxBytes), cfg.AuditMaxFiles)
+		if err != nil {
+			log.Printf("WARNING: terminal audit log disabled: %v", err)
+		} else {
+			r.audit = audit
+		}
+	}
+	return r
+}
+
+func (r *registry) get(id string) *Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[id]
+}
+
+// list returns the live sessions, optionally only those in containerID.
+func (r *registry) list(containerID string) []*Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		if containerID == "" || s.ContainerID == containerID {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+// acquire returns the session sessionID if it runs in containerID or, when
+// sessionID is empty, starts a new one with spec.
+func (r *registry) acquire(containerID, sessionID, owner string, spec execSpec) (*Session, error) {
+	if sessionID == "" {
+		return r.create(containerID, owner, spec)
+	}
+	if s := r.get(sessionID); s != nil && s.ContainerID == containerID {
+		return s, nil
+	}
+	return nil, fmt.Errorf("session not found: %s", sessionID)
+}
+
+// create starts a new interactive shell exec in the container, or attaches
+// to its main process if spec.Main is set, and registers it. The stream is
+// bound to a background context because it must survive the HTTP request
+// that created it. It fails with a *limitError if the session would exceed
+// a concurrency limit.
+func (r *registry) create(containerID, owner string, spec execSpec) (*Session, error) {
+	sl, err := r.reserve(containerID, owner)
+	if err != nil {
+		return nil, err
+	}
+	registered := false
+	defer func() {
+		if !registered {
+			r.release(sl)
+		}
+	}()
+
+	ctx := context.Background()
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ID:          id,
+		ContainerID: containerID,
+		Owner:       owner,
+		spec:        spec,
+		reg:         r,
+		scrollback:  newRingBuffer(r.cfg.ScrollbackSize),
+		clients:     make(map[*subscriber]struct{}),
+		done:        make(chan struct{}),
+		credit:      make(chan struct{}, 1),
+	}
+	if spec.Main {
+		err = s.attachMain(ctx)
+	} else {
+		err = s.startExec(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Created = time.Now()
+	s.touch()
+
+	if r.cfg.RecordingDir != "" {
+		rec, err := newRecorder(r.cfg.RecordingDir, containerID, id, 80, 24, map[string]string{
+			"TERM":  "xterm",
+			"SHELL": s.spec.Cmd,
+		})
+		if err != nil {
+			log.Printf("[session %s] recording disabled: %v", id, err)
+		} else {
+			s.rec = rec
+		}
+	}
+
+	r.register(sl, s)
+	registered = true
+
+	go s.pump()
+	if r.cfg.IdleTimeout > 0 {
+		go s.watchIdle(r.cfg.IdleTimeout)
+	}
+	return s, nil
+}
+
+// startExec creates the session's shell exec and attaches to it.
+func (s *Session) startExec(ctx context.Context) error {
+	env := append(append([]string(nil), s.spec.Env...), sessionEnvKey+"="+s.ID)
+
+	// The container runs "sleep infinity" as its main process;
+	// we exec a shell with a TTY to get an actual terminal session.
+	execResp, err := s.reg.cli.ContainerExecCreate(ctx, s.ContainerID, container.ExecOptions{
+		Cmd:          s.spec.argv(),
+		User:         s.spec.User,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Env:          env,
+		WorkingDir:   s.spec.Root,
+	})
+	if err != nil {
+		return fmt.Errorf("exec create error: %w", err)
+	}
+
+	hijack, err := s.reg.cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{
+		Tty: true,
+	})
+	if err != nil {
+		return fmt.Errorf("exec attach error: %w", err)
+	}
+	s.ExecID = execResp.ID
+	s.hijack = hijack
+	s.out = hijack.Reader
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// pump copies exec output into the scrollback and to every attached client,
+// then finishes the session once the stream ends.
+func (s *Session) pump() {
+	buf := make([]byte, readBufSize)
+	var readErr error
+	for {
+		s.waitForCredit()
+		var n int
+		n, readErr = s.out.Read(buf)
+		if n > 0 {
+			s.touch()
+			s.bytesOut.Add(int64(n))
+			chunk := append([]byte(nil), buf[:n]...)
+			if s.rec != nil {
+				s.rec.Output(chunk)
+			}
+			s.mu.Lock()
+			_, _ = s.scrollback.Write(chunk)
+			for c := range s.clients {
+				if !c.sendData(chunk) {
+					delete(s.clients, c)
+				}
+			}
+			for _, u := range s.osc.feed(chunk) {
+				s.applyOSCLocked(u)
+			}
+			s.detachedLocked()
+			s.mu.Unlock()
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	s.finish(readErr)
+}
+
+// applyOSCLocked records a title or cwd reported by the terminal and tells
+// every client about changes. s.mu must be held.
+func (s *Session) applyOSCLocked(u oscUpdate) {
+	var ev serverEvent
+	switch {
+	case u.cwd != "" && u.cwd != s.cwd:
+		s.cwd = u.cwd
+		ev = serverEvent{Type: "cwd", Path: u.cwd}
+	case u.cwd == "" && u.title != s.title:
+		s.title = u.title
+		ev = serverEvent{Type: "title", Title: u.title}
+	default:
+		return
+	}
+	for c := range s.clients {
+		c.sendEvent(ev)
+	}
+}
+
+// waitForCredit blocks while any flow-controlled client has a full window,
+// so that slow clients throttle reads from the exec instead of letting
+// output pile up in memory.
+func (s *Session) waitForCredit() {
+	for {
+		s.mu.Lock()
+		blocked := false
+		for c := range s.clients {
+			if !c.hasCredit() {
+				blocked = true
+				break
+			}
+		}
+		if s.terminated != "" {
+			blocked = false
+		}
+		s.mu.Unlock()
+		if !blocked {
+			return
+		}
+		<-s.credit
+	}
+}
+
+// Ack records that c has processed n bytes of output.
+func (s *Session) Ack(c *subscriber, n int) {
+	c.ack(n)
+	s.notifyCredit()
+}
+
+func (s *Session) notifyCredit() {
+	select {
+	case s.credit <- struct{}{}:
+	default:
+	}
+}
+
+// Join subscribes c to the session's output. The scrollback is queued to c
+// before it starts receiving live output; both happen under s.mu so no
+// output is lost or duplicated in between.
+func (s *Session) Join(c *subscriber) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("session %s has ended", s.ID)
+	}
+	if s.reaper != nil {
+		s.reaper.Stop()
+		s.reaper = nil
+	}
+	if replay := s.scrollback.Bytes(); len(replay) > 0 {
+		c.sendData(replay)
+	}
+	if s.cwd != "" {
+		c.sendEvent(serverEvent{Type: "cwd", Path: s.cwd})
+	}
+	if s.title != "" {
+		c.sendEvent(serverEvent{Type: "title", Title: s.title})
+	}
+	s.clients[c] = struct{}{}
+	return nil
+}
+
+// Leave unsubscribes c. When the last client leaves, the exec is scheduled
+// to be reaped unless someone re-attaches within the grace period.
+func (s *Session) Leave(c *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c]; !ok || s.closed {
+		return
+	}
+	delete(s.clients, c)
+	s.detachedLocked()
+	s.notifyCredit()
+}
+
+// detachedLocked starts the reaper if no client is attached. s.mu must be held.
+func (s *Session) detachedLocked() {
+	if len(s.clients) > 0 || s.reaper != nil || s.closed {
+		return
+	}
+	s.reaper = time.AfterFunc(s.reg.cfg.SessionGrace, func() {
+		log.Printf("[session %s] no client for %s, reaping exec %s", s.ID, s.reg.cfg.SessionGrace, s.ExecID)
+		s.Terminate(reasonReaped)
+	})
+}
+
+// Write sends input to the exec's stdin.
+func (s *Session) Write(p []byte) (int, error) {
+	s.touch()
+	s.bytesIn.Add(int64(len(p)))
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.hijack.Conn.Write(p)
+}
+
+func (s *Session) Resize(ctx context.Context, cols, rows uint) error {
+	opts := container.ResizeOptions{
+		Height: rows,
+		Width:  cols,
+	}
+	var err error
+	if s.spec.Main {
+		err = s.reg.cli.ContainerResize(ctx, s.ContainerID, opts)
+	} else {
+		err = s.reg.cli.ContainerExecResize(ctx, s.ExecID, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if s.rec != nil {
+		s.rec.Resize(cols, rows)
+	}
+	return nil
+}
+
+func (s *Session) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// LastActivity is the time of the last input or output.
+func (s *Session) LastActivity() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
+}
+
+// watchIdle terminates the session once it has seen no input or output for
+// timeout.
+func (s *Session) watchIdle(timeout time.Duration) {
+	check := timeout / 10
+	if check < time.Second {
+		check = time.Second
+	}
+	t := time.NewTicker(check)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if idle := time.Since(s.LastActivity()); idle >= timeout {
+				log.Printf("[session %s] idle for %s, terminating", s.ID, idle.Round(time.Second))
+				s.Terminate(reasonIdle)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Done is closed once the session has finished.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Terminate ends the session on the server's initiative, reporting reason
+// to attached clients. Every process started from the session is killed,
+// since closing the stream alone would leave a busy program running, and
+// closing the hijacked connection ends the pump. A session on the main
+// process is only detached; the container keeps running.
+func (s *Session) Terminate(reason string) {
+	s.mu.Lock()
+	if s.closed || s.terminated != "" {
+		s.mu.Unlock()
+		return
+	}
+	s.terminated = reason
+	s.mu.Unlock()
+
+	if !s.spec.Main {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := docker.KillMarked(ctx, s.reg.cli, s.ContainerID, s.spec.User, sessionEnvKey+"="+s.ID, "KILL"); err != nil {
+			log.Printf("[session %s] kill processes: %v", s.ID, err)
+		}
+	}
+
+	s.notifyCredit()
+	s.hijack.Close()
+}
+
+// finish reports the exit status to every attached client, closes their
+// sockets and unregisters the session.
+func (s *Session) finish(readErr error) {
+	s.mu.Lock()
+	terminated := s.terminated
+	s.mu.Unlock()
+
+	if readErr != io.EOF && terminated == "" {
+		log.Printf("[session %s] read from docker failed: %v", s.ID, readErr)
+	}
+	st := s.exitStatus(readErr, terminated)
+
+	s.mu.Lock()
+	s.closed = true
+	if s.reaper != nil {
+		s.reaper.Stop()
+		s.reaper = nil
+	}
+	for c := range s.clients {
+		c.sendEvent(st.event())
+		c.shutdown(st.closeCode(), st.closeText())
+	}
+	s.clients = nil
+	close(s.done)
+	s.mu.Unlock()
+
+	s.hijack.Close()
+	if s.rec != nil {
+		if err := s.rec.Close(); err != nil {
+			log.Printf("[session %s] close recording: %v", s.ID, err)
+		}
+	}
+	s.reg.remove(s.ID)
+	log.Printf("[session %s] ended: %s (container %s, exec %s)", s.ID, st.closeText(), s.ContainerID, s.ExecID)
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+)
+
+// sessionEnvKey is set in every session's exec environment so the shell
+// can be found from inside the container. ContainerExecInspect reports the
+// PID in the daemon's PID namespace, which is not valid inside the
+// container, so it is only used to check that the exec is still running.
+const sessionEnvKey = "PTY_PROXY_SESSION"
+
+// Signal targets.
+const (
+	// targetForeground signals the TTY's foreground process group, i.e. the
+	// program currently running in the terminal, like typing ^C does.
+	targetForeground = "foreground"
+	// targetShell signals the shell's own process group.
+	targetShell = "shell"
+)
+
+var allowedSignals = map[string]bool{
+	"SIGINT":  true,
+	"SIGTERM": true,
+	"SIGKILL": true,
+	"SIGTSTP": true,
+	"SIGCONT": true,
+	"SIGQUIT": true,
+	"SIGHUP":  true,
+}
+
+// signalScript finds the session's shell (the process carrying the marker
+// variable whose parent is outside the container) and signals the process
+// group selected by target. Exit status 2 means the shell was not found.
+const signalScript = `sig=$1 target=$2 marker=$3
+for d in /proc/[0-9]*; do
+	tr '\0' '\n' <"$d/environ" 2>/dev/null | grep -qx "$marker" || continue
+	s=$(cat "$d/stat" 2>/dev/null) || continue
+	s=${s##*") "}
+	set -- $s
+	[ "$2" = 0 ] || continue
+	if [ "$target" = shell ]; then pg=$3; else pg=$6; fi
+	[ "$pg" -gt 0 ] 2>/dev/null || exit 3
+	kill -s "$sig" -- "-$pg"
+	exit $?
+done
+exit 2`
+
+func normalizeSignal(sig string) (string, error) {
+	sig = strings.ToUpper(strings.TrimSpace(sig))
+	if !strings.HasPrefix(sig, "SIG") {
+		sig = "SIG" + sig
+	}
+	if !allowedSignals[sig] {
+		return "", fmt.Errorf("unsupported signal %q", sig)
+	}
+	return sig, nil
+}
+
+// Signal delivers sig to the process group selected by target inside the
+// container, or to the main process for a session attached to it. If the
+// shell cannot be located from inside the container, the signals a
+// terminal can generate itself fall back to typing the matching control
+// character.
+func (s *Session) Signal(ctx context.Context, sig, target string) error {
+	sig, err := normalizeSignal(sig)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		target = targetForeground
+	}
+	if target != targetForeground && target != targetShell {
+		return fmt.Errorf("unknown signal target %q", target)
+	}
+	if s.spec.Main {
+		// The main process is PID 1 of the container; Docker can signal it
+		// directly, whatever the target. Only SIGINT is forwarded, as
+		// Ctrl-C is by "docker attach". Most programs exit on it, which
+		// usually stops the container too; the other signals are refused
+		// so that a client cannot kill or suspend PID 1 outright.
+		if sig != "SIGINT" {
+			return fmt.Errorf("only SIGINT can be sent to the main process, not %s", sig)
+		}
+		if err := s.reg.cli.ContainerKill(ctx, s.ContainerID, sig); err != nil {
+			return fmt.Errorf("signal: %w", err)
+		}
+		return nil
+	}
+
+	insp, err := s.reg.cli.ContainerExecInspect(ctx, s.ExecID)
+	if err != nil {
+		return fmt.Errorf("exec inspect: %w", err)
+	}
+	if !insp.Running {
+		return fmt.Errorf("session %s is not running", s.ID)
+	}
+
+	res, err := docker.Run(ctx, s.reg.cli, s.ContainerID, s.spec.User, []string{
+		"/bin/sh", "-c", signalScript, "sh",
+		strings.TrimPrefix(sig, "SIG"), target, sessionEnvKey + "=" + s.ID,
+	})
+	if err == nil && res.ExitCode == 0 {
+		return nil
+	}
+
+	if ch, ok := ttySignals[sig]; ok && target == targetForeground {
+		if err != nil {
+			log.Printf("[session %s] signal via exec failed, using tty: %v", s.ID, err)
+		}
+		_, werr := s.Write([]byte{ch})
+		return werr
+	}
+	if err != nil {
+		return fmt.Errorf("signal: %w", err)
+	}
+	return fmt.Errorf("signal %s failed (exit %d): %s", sig, res.ExitCode, strings.TrimSpace(string(res.Stderr)))
+}
+
+// signalHandler delivers a signal to a session's terminal:
+// POST /signal?id={container}&session={session}&signal=SIGINT[&target=shell]
+// Viewers learn the session ID too, so knowing it is not enough: the
+// caller must be the session's owner, as identified by the gateway's user
+// header, or present the admin token.
+func signalHandler(reg *registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		containerID := r.URL.Query().Get("id")
+		sessionID := r.URL.Query().Get("session")
+		if containerID == "" || sessionID == "" {
+			http.Error(w, `missing "id" or "session" query parameter`, http.StatusBadRequest)
+			return
+		}
+
+		sess := reg.get(sessionID)
+		if sess == nil || sess.ContainerID != containerID {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		if !reg.cfg.ownsSession(r, sess) && !isAdmin(reg.cfg.AdminToken, r) {
+			http.Error(w, "only the session's owner may send signals", http.StatusForbidden)
+			return
+		}
+
+		sig := r.URL.Query().Get("signal")
+		if sig == "" {
+			sig = "SIGINT"
+		}
+		if err := sess.Signal(r.Context(), sig, r.URL.Query().Get("target")); err != nil {
+			log.Printf("[signal] session %s: %v", sessionID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+// ownsSession reports whether the gateway identified the caller of r as
+// the user who started sess. Sessions started without a user have no
+// owner to match.
+func (c Config) ownsSession(r *http.Request, sess *Session) bool {
+	return c.UserHeader != "" && sess.Owner != "" && r.Header.Get(c.UserHeader) == sess.Owner
+}
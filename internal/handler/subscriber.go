@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/utf8safe"
+	"github.com/gorilla/websocket"
+)
+
+// Role controls what a client attached to a shared session may do.
+type Role string
+
+const (
+	// RoleOwner may type and resize the terminal.
+	RoleOwner Role = "owner"
+	// RoleCollaborator may type but the terminal size follows the owner.
+	RoleCollaborator Role = "collaborator"
+	// RoleViewer only receives output; its input is dropped.
+	RoleViewer Role = "viewer"
+)
+
+func parseRole(s string) (Role, error) {
+	switch Role(s) {
+	case RoleOwner, RoleCollaborator, RoleViewer:
+		return Role(s), nil
+	}
+	return "", fmt.Errorf("unknown role %q", s)
+}
+
+func (r Role) canWrite() bool  { return r == RoleOwner || r == RoleCollaborator }
+func (r Role) canResize() bool { return r == RoleOwner }
+
+// subscriberOptions tune how output is delivered to one client.
+type subscriberOptions struct {
+	pingInterval time.Duration
+	// coalesceDelay is how long the writer waits after being woken so that
+	// output arriving in quick succession is sent as one frame.
+	coalesceDelay time.Duration
+	// maxFrame caps the terminal bytes carried by one frame.
+	maxFrame int
+	// maxQueue is the number of queued bytes after which a client without
+	// flow control is considered too slow and disconnected.
+	maxQueue int
+	// window enables acknowledgement-based flow control: at most window
+	// bytes may be sent but not yet acknowledged. Zero disables it.
+	window int
+	// utf8 makes every output frame valid UTF-8: incomplete trailing
+	// sequences are held back until the rest arrives and invalid bytes are
+	// replaced with U+FFFD.
+	utf8 bool
+}
+
+// outItem is either terminal output, framed by the codec when it is
+// written, or an already framed message.
+type outItem struct {
+	data    []byte
+	isData  bool
+	mt      int
+	payload []byte
+}
+
+// conn is the write side of the socket a subscriber delivers to: a
+// *websocket.Conn, or one channel of a multiplexed socket.
+type conn interface {
+	WriteMessage(mt int, data []byte) error
+	WriteControl(mt int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// subscriber is one WebSocket attached to a session. All writes to the socket
+// go through its writer goroutine so the session pump never blocks on a
+// slow peer.
+type subscriber struct {
+	ws    conn
+	codec codec
+	role  Role
+	addr  string
+	opts  subscriberOptions
+
+	qmu     sync.Mutex
+	queue   []outItem
+	queued  int // bytes in queue
+	unacked int // bytes sent since the last acknowledgement
+	wake    chan struct{}
+
+	// pending holds an incomplete UTF-8 sequence in utf8 mode; only the
+	// writer goroutine touches it.
+	pending []byte
+
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	// line reconstructs typed commands for the audit log; only the
+	// client's read loop touches it.
+	line lineEditor
+
+	connected    time.Time
+	lastActivity atomic.Int64 // unix nanoseconds of the last message from the client
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+}
+
+func newSubscriber(ws conn, cd codec, role Role, addr string, opts subscriberOptions) *subscriber {
+	c := &subscriber{
+		ws:    ws,
+		codec: cd,
+		role:  role,
+		addr:  addr,
+		opts:  opts,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+
+		connected: time.Now(),
+	}
+	c.lastActivity.Store(c.connected.UnixNano())
+	go c.writer()
+	return c
+}
+
+// enqueue adds it to the queue without blocking, merging consecutive
+// terminal output up to maxFrame. It reports false if the client is closed
+// or, without flow control, has fallen more than maxQueue bytes behind.
+func (c *subscriber) enqueue(it outItem) bool {
+	c.qmu.Lock()
+	select {
+	case <-c.stop:
+		c.qmu.Unlock()
+		return false
+	default:
+	}
+
+	n := len(it.data) + len(it.payload)
+	if last := len(c.queue) - 1; it.isData && last >= 0 && c.queue[last].isData &&
+		len(c.queue[last].data)+len(it.data) <= c.opts.maxFrame {
+		c.queue[last].data = append(c.queue[last].data, it.data...)
+	} else {
+		if it.isData {
+			// The slice is shared with other subscribers; own a copy so
+			// later merges cannot write into it.
+			it.data = slices.Clone(it.data)
+		}
+		c.queue = append(c.queue, it)
+	}
+	c.queued += n
+	if it.isData && c.opts.window > 0 {
+		c.unacked += len(it.data)
+	}
+	tooSlow := c.opts.window == 0 && c.queued > c.opts.maxQueue
+	c.qmu.Unlock()
+
+	if tooSlow {
+		log.Printf("[attach] client %s too slow, disconnecting", c.addr)
+		c.close()
+		return false
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// sendData queues terminal output.
+func (c *subscriber) sendData(p []byte) bool {
+	c.bytesOut.Add(int64(len(p)))
+	return c.enqueue(outItem{data: p, isData: true})
+}
+
+// received records a message of n input bytes from the client.
+func (c *subscriber) received(n int) {
+	c.lastActivity.Store(time.Now().UnixNano())
+	c.bytesIn.Add(int64(n))
+}
+
+// sendEvent queues a control event if the client's protocol can carry it.
+func (c *subscriber) sendEvent(ev serverEvent) bool {
+	mt, payload, ok := c.codec.event(ev)
+	if !ok {
+		return true
+	}
+	return c.enqueue(outItem{mt: mt, payload: payload})
+}
+
+// hasCredit reports whether the client's flow-control window has room.
+func (c *subscriber) hasCredit() bool {
+	if c.opts.window == 0 {
+		return true
+	}
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+	return c.unacked < c.opts.window
+}
+
+// ack records that the client has processed n bytes of output.
+func (c *subscriber) ack(n int) {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+	c.unacked = max(c.unacked-n, 0)
+}
+
+// writer drains the queue and pings the peer every pingInterval so that
+// proxies keep the connection open and dead peers are detected by the
+// reader's deadline.
+func (c *subscriber) writer() {
+	var ping <-chan time.Time
+	if c.opts.pingInterval > 0 {
+		t := time.NewTicker(c.opts.pingInterval)
+		defer t.Stop()
+		ping = t.C
+	}
+	for {
+		select {
+		case <-ping:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeDeadline)); err != nil {
+				log.Printf("[attach] ping to %s failed: %v", c.addr, err)
+				c.close()
+				return
+			}
+
+		case <-c.wake:
+			if c.opts.coalesceDelay > 0 {
+				select {
+				case <-time.After(c.opts.coalesceDelay):
+				case <-c.stop:
+					return
+				}
+			}
+			c.qmu.Lock()
+			items := c.queue
+			c.queue = nil
+			c.queued = 0
+			c.qmu.Unlock()
+
+			for _, it := range items {
+				if err := c.write(it); err != nil {
+					if err != errClosing {
+						log.Printf("[attach] write to websocket %s failed: %v", c.addr, err)
+					}
+					c.close()
+					return
+				}
+			}
+
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// errClosing is returned by write after it has sent a close frame.
+var errClosing = errors.New("closing")
+
+func (c *subscriber) write(it outItem) error {
+	if !it.isData {
+		if it.mt == websocket.CloseMessage {
+			if len(c.pending) > 0 {
+				// The stream ended mid-sequence.
+				mt, payload := c.codec.data(utf8safe.Replacement)
+				_ = c.ws.SetWriteDeadline(time.Now().Add(writeDeadline))
+				_ = c.ws.WriteMessage(mt, payload)
+			}
+			_ = c.ws.WriteControl(websocket.CloseMessage, it.payload, time.Now().Add(time.Second))
+			return errClosing
+		}
+		_ = c.ws.SetWriteDeadline(time.Now().Add(writeDeadline))
+		return c.ws.WriteMessage(it.mt, it.payload)
+	}
+	p := it.data
+	if c.opts.utf8 {
+		if len(c.pending) > 0 {
+			p = slices.Concat(c.pending, p)
+		}
+		p, c.pending = utf8safe.Split(p)
+	}
+	for len(p) > 0 {
+		n := min(len(p), c.opts.maxFrame)
+		frame := p[:n]
+		if c.opts.utf8 {
+			n = utf8safe.Boundary(p, n)
+			frame = bytes.ToValidUTF8(p[:n], utf8safe.Replacement)
+		}
+		mt, payload := c.codec.data(frame)
+		_ = c.ws.SetWriteDeadline(time.Now().Add(writeDeadline))
+		if err := c.ws.WriteMessage(mt, payload); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// shutdown closes the connection with a close frame once everything queued
+// before it has been written.
+func (c *subscriber) shutdown(code int, text string) {
+	c.enqueue(outItem{mt: websocket.CloseMessage, payload: websocket.FormatCloseMessage(code, text)})
+}
+
+// close stops the writer and closes the socket, which also ends the
+// client's read loop.
+func (c *subscriber) close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		_ = c.ws.Close()
+	})
+}
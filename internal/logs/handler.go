@@ -0,0 +1,189 @@
+package logs
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/eventstream"
+)
+
+func Register(mux *http.ServeMux, cli *client.Client) {
+	svc := New(cli)
+	mux.HandleFunc("/logs/download", downloadHandler(svc))
+	mux.HandleFunc("/logs/stream", streamHandler(svc))
+	mux.HandleFunc("/logs/ws", wsHandler(svc))
+}
+
+// event ends the streaming endpoints; the output itself is sent as
+// eventstream "stdout" and "stderr" events.
+type event struct {
+	Type    string `json:"type"` // "end" or "error"
+	Message string `json:"message,omitempty"`
+}
+
+func parseRequest(w http.ResponseWriter, r *http.Request) (string, Options, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", Options{}, false
+	}
+
+	containerID := r.URL.Query().Get("id")
+	if containerID == "" {
+		http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+		return "", Options{}, false
+	}
+
+	opts, err := parseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", Options{}, false
+	}
+	return containerID, opts, true
+}
+
+// open opens the log or answers with an error status.
+func open(w http.ResponseWriter, r *http.Request, svc *Service, containerID string, opts Options) (*Log, bool) {
+	l, err := svc.Open(r.Context(), containerID, opts)
+	if err != nil {
+		log.Printf("[logs] open error for container %s: %v", containerID, err)
+		if errdefs.IsNotFound(err) {
+			http.Error(w, "container not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "failed to read logs", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return l, true
+}
+
+// flushWriter flushes after every write so that followed output reaches
+// the client as it is produced.
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.flusher.Flush()
+	return n, err
+}
+
+// downloadHandler returns the log as a plain-text attachment, with stdout
+// and stderr interleaved: GET /logs/download?id={container}[&tail=100...].
+func downloadHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, opts, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+		l, ok := open(w, r, svc, containerID, opts)
+		if !ok {
+			return
+		}
+		defer l.Close()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+containerID+`.log"`)
+		var out io.Writer = w
+		if flusher, ok := w.(http.Flusher); ok && opts.Follow {
+			out = flushWriter{w: w, flusher: flusher}
+		}
+		if err := l.Copy(out, out); err != nil && r.Context().Err() == nil {
+			log.Printf("[logs/download] copy error for container %s: %v", containerID, err)
+		}
+	}
+}
+
+// copyEvents copies the log to s as "stdout"/"stderr" events followed by
+// "end" or "error". It reports whether the log ended normally.
+func copyEvents(ctx context.Context, l *Log, s eventstream.Sender, tag, containerID string) bool {
+	out := eventstream.NewOutput(s, "stdout")
+	errOut := eventstream.NewOutput(s, "stderr")
+	err := l.Copy(out, errOut)
+	_ = out.Flush()
+	_ = errOut.Flush()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[%s] copy error for container %s: %v", tag, containerID, err)
+			_ = s.Send("error", event{Type: "error", Message: err.Error()})
+		}
+		return false
+	}
+	_ = s.Send("end", event{Type: "end"})
+	return true
+}
+
+// streamHandler streams the log as server-sent events ("stdout", "stderr",
+// then "end" or "error"): GET /logs/stream?id={container}&follow=true.
+func streamHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, opts, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+		sse, err := eventstream.NewSSE(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		l, ok := open(w, r, svc, containerID, opts)
+		if !ok {
+			return
+		}
+		defer l.Close()
+
+		sse.Start()
+		copyEvents(r.Context(), l, sse, "logs/stream", containerID)
+	}
+}
+
+// wsHandler streams the log over a WebSocket as "stdout"/"stderr" events
+// followed by "end" or "error": GET /logs/ws?id={container}&follow=true.
+// Options are validated before the upgrade.
+func wsHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, opts, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+
+		ws, err := eventstream.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[logs/ws] websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		sender := eventstream.NewWS(ws)
+
+		// The client sends nothing; reading only notices when it goes away,
+		// which stops a followed log.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		l, err := svc.Open(ctx, containerID, opts)
+		if err != nil {
+			log.Printf("[logs/ws] open error for container %s: %v", containerID, err)
+			_ = sender.Send("error", event{Type: "error", Message: err.Error()})
+			return
+		}
+		defer l.Close()
+
+		if copyEvents(ctx, l, sender, "logs/ws", containerID) {
+			_ = sender.Close()
+		}
+	}
+}
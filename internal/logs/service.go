@@ -0,0 +1,135 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Options selects the part of a container's log to stream.
+type Options struct {
+	Follow     bool
+	Tail       string // "all" or a number of lines
+	Since      string
+	Until      string
+	Timestamps bool
+	Stdout     bool
+	Stderr     bool
+}
+
+// unixTimeRe matches a Unix timestamp with optional fractional seconds, one
+// of the forms Docker accepts for since and until.
+var unixTimeRe = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,9})?$`)
+
+// validTime reports whether v is a time Docker accepts: RFC 3339, a Unix
+// timestamp, or a duration relative to now such as "10m".
+func validTime(v string) bool {
+	if unixTimeRe.MatchString(v) {
+		return true
+	}
+	if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return true
+	}
+	_, err := time.ParseDuration(v)
+	return err == nil
+}
+
+// parseOptions reads follow, tail, since, until, timestamps, stdout and
+// stderr from the query string. Both streams are included by default.
+func parseOptions(q url.Values) (Options, error) {
+	opts := Options{Tail: "all", Stdout: true, Stderr: true}
+
+	for key, dst := range map[string]*bool{
+		"follow":     &opts.Follow,
+		"timestamps": &opts.Timestamps,
+		"stdout":     &opts.Stdout,
+		"stderr":     &opts.Stderr,
+	} {
+		if v := q.Get(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %q parameter %q", key, v)
+			}
+			*dst = b
+		}
+	}
+	if !opts.Stdout && !opts.Stderr {
+		return opts, fmt.Errorf(`at least one of "stdout" and "stderr" must be enabled`)
+	}
+
+	if v := q.Get("tail"); v != "" && v != "all" {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return opts, fmt.Errorf(`invalid "tail" parameter %q, must be "all" or a number of lines`, v)
+		}
+		opts.Tail = v
+	}
+	for key, dst := range map[string]*string{"since": &opts.Since, "until": &opts.Until} {
+		if v := q.Get(key); v != "" {
+			if !validTime(v) {
+				return opts, fmt.Errorf("invalid %q parameter %q, must be RFC 3339, a Unix timestamp or a duration", key, v)
+			}
+			*dst = v
+		}
+	}
+	return opts, nil
+}
+
+type Service struct {
+	cli *client.Client
+}
+
+func New(cli *client.Client) *Service {
+	return &Service{cli: cli}
+}
+
+// Log is an open container log stream.
+type Log struct {
+	rc  io.ReadCloser
+	tty bool
+}
+
+// Open starts reading the container's log. With Follow the stream stays
+// open for new output until ctx is cancelled or the container stops.
+func (s *Service) Open(ctx context.Context, containerID string, opts Options) (*Log, error) {
+	info, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("container inspect: %w", err)
+	}
+	rc, err := s.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: opts.Stdout,
+		ShowStderr: opts.Stderr,
+		Since:      opts.Since,
+		Until:      opts.Until,
+		Timestamps: opts.Timestamps,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("container logs: %w", err)
+	}
+	return &Log{rc: rc, tty: info.Config.Tty}, nil
+}
+
+// Copy writes the log to stdout and stderr until it ends. A container
+// with a TTY has a single stream, which all goes to stdout.
+func (l *Log) Copy(stdout, stderr io.Writer) error {
+	var err error
+	if l.tty {
+		_, err = io.Copy(stdout, l.rc)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, l.rc)
+	}
+	return err
+}
+
+func (l *Log) Close() error {
+	return l.rc.Close()
+}
@@ -0,0 +1,203 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/docker/docker/client"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/eventstream"
+	"github.com/gorilla/websocket"
+)
+
+const maxRequestSize = 5 * 1024 * 1024 // request JSON, including stdin
+
+func Register(mux *http.ServeMux, cli *client.Client) {
+	svc := New(cli)
+	mux.HandleFunc("/run", runHandler(svc))
+	mux.HandleFunc("/run/stream", streamHandler(svc))
+	mux.HandleFunc("/run/ws", wsHandler(svc))
+}
+
+// runResponse is the body returned by the buffered /run endpoint.
+type runResponse struct {
+	Result
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool   `json:"stderrTruncated,omitempty"`
+}
+
+// event is one message of the streaming endpoints.
+type event struct {
+	Type    string  `json:"type"` // "stdout", "stderr", "exit" or "error"
+	Data    string  `json:"data,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Result  *Result `json:"result,omitempty"`
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request) (string, Request, bool) {
+	var req Request
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", req, false
+	}
+
+	containerID := r.URL.Query().Get("id")
+	if containerID == "" {
+		http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+		return "", req, false
+	}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return "", req, false
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", req, false
+	}
+	return containerID, req, true
+}
+
+// runHandler runs a command and returns its buffered output and exit code:
+// POST /run?id={container} with a Request body.
+func runHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, req, ok := decodeRequest(w, r)
+		if !ok {
+			return
+		}
+
+		stdout := &limitedBuffer{max: maxOutput}
+		stderr := &limitedBuffer{max: maxOutput}
+		res, err := svc.Run(r.Context(), containerID, req, nil, stdout, stderr)
+		if err != nil {
+			log.Printf("[run] error for container %s: %v", containerID, err)
+			http.Error(w, "failed to run command: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(runResponse{
+			Result:          *res,
+			Stdout:          string(stdout.buf),
+			Stderr:          string(stderr.buf),
+			StdoutTruncated: stdout.truncated,
+			StderrTruncated: stderr.truncated,
+		}); err != nil {
+			log.Printf("[run] encode error: %v", err)
+		}
+	}
+}
+
+// runStreamed runs req with its output sent as events on s, followed by
+// "exit" or "error".
+func runStreamed(ctx context.Context, svc *Service, s eventstream.Sender, tag, containerID string, req Request, stdin io.Reader) {
+	out := eventstream.NewOutput(s, "stdout")
+	errOut := eventstream.NewOutput(s, "stderr")
+	res, err := svc.Run(ctx, containerID, req, stdin, out, errOut)
+	_ = out.Flush()
+	_ = errOut.Flush()
+	if err != nil {
+		log.Printf("[%s] error for container %s: %v", tag, containerID, err)
+		_ = s.Send("error", event{Type: "error", Message: err.Error()})
+		return
+	}
+	_ = s.Send("exit", event{Type: "exit", Result: res})
+}
+
+// streamHandler runs a command and streams its output as server-sent events
+// ("stdout", "stderr", then "exit" or "error"):
+// POST /run/stream?id={container} with a Request body.
+func streamHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID, req, ok := decodeRequest(w, r)
+		if !ok {
+			return
+		}
+		sse, err := eventstream.NewSSE(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sse.Start()
+		runStreamed(r.Context(), svc, sse, "run/stream", containerID, req, nil)
+	}
+}
+
+// wsHandler runs a command over a WebSocket: GET /run/ws?id={container}.
+// The first text frame is the Request; after it, binary frames are written
+// to the command's stdin and a {"type":"eof"} text frame closes stdin.
+// Output arrives as "stdout"/"stderr" events followed by "exit" or "error".
+func wsHandler(svc *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		containerID := r.URL.Query().Get("id")
+		if containerID == "" {
+			http.Error(w, `missing "id" query parameter`, http.StatusBadRequest)
+			return
+		}
+
+		ws, err := eventstream.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[run/ws] websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		sender := eventstream.NewWS(ws)
+
+		var req Request
+		if err := ws.ReadJSON(&req); err != nil {
+			_ = sender.Send("error", event{Type: "error", Message: "invalid request: " + err.Error()})
+			return
+		}
+
+		// Pump the socket into stdin until EOF, then keep reading so that
+		// a client that goes away cancels the command.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stdinR, stdinW := io.Pipe()
+		defer stdinR.Close()
+		go func() {
+			defer cancel()
+			stdinOpen := true
+			closeStdin := func() {
+				if stdinOpen {
+					_ = stdinW.Close()
+					stdinOpen = false
+				}
+			}
+			defer closeStdin()
+
+			if req.Stdin != "" {
+				if _, err := io.WriteString(stdinW, req.Stdin); err != nil {
+					stdinOpen = false
+				}
+			}
+			for {
+				mt, payload, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				if mt == websocket.TextMessage {
+					var msg event
+					if json.Unmarshal(payload, &msg) == nil && msg.Type == "eof" {
+						closeStdin()
+					}
+					continue
+				}
+				if stdinOpen {
+					if _, err := stdinW.Write(payload); err != nil {
+						stdinOpen = false
+					}
+				}
+			}
+		}()
+
+		runStreamed(ctx, svc, sender, "run/ws", containerID, req, stdinR)
+		_ = sender.Close()
+	}
+}
@@ -0,0 +1,206 @@
+package run
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/edu-project-ai/docker-pty-proxy/internal/docker"
+	"github.com/edu-project-ai/docker-pty-proxy/internal/workspace"
+)
+
+const (
+	defaultTimeout = 60 * time.Second
+	maxTimeout     = 10 * time.Minute
+	maxOutput      = 1024 * 1024 // per stream, for the buffered endpoint
+
+	// markerEnvKey tags every process started by Run so it can be killed
+	// on timeout; Docker has no API to stop an exec.
+	markerEnvKey = "PTY_PROXY_RUN"
+)
+
+// Request describes a command to run without a TTY.
+type Request struct {
+	Cmd       []string `json:"cmd"`
+	Stdin     string   `json:"stdin,omitempty"`
+	Env       []string `json:"env,omitempty"`
+	WorkDir   string   `json:"workdir,omitempty"` // relative to /workspace
+	TimeoutMs int      `json:"timeoutMs,omitempty"`
+}
+
+// Result is the outcome of a finished command.
+type Result struct {
+	ExitCode   int   `json:"exitCode"`
+	TimedOut   bool  `json:"timedOut"`
+	DurationMs int64 `json:"durationMs"`
+}
+
+type Service struct {
+	cli *client.Client
+}
+
+func New(cli *client.Client) *Service {
+	return &Service{cli: cli}
+}
+
+func (req *Request) validate() error {
+	if len(req.Cmd) == 0 || req.Cmd[0] == "" {
+		return fmt.Errorf("cmd must not be empty")
+	}
+	for _, kv := range req.Env {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || !workspace.ValidEnvKey(key) || key == markerEnvKey {
+			return fmt.Errorf("invalid env %q, expected KEY=VALUE", kv)
+		}
+	}
+	if req.WorkDir != "" {
+		cleaned := path.Clean(req.WorkDir)
+		if strings.ContainsRune(cleaned, 0) || strings.HasPrefix(cleaned, "/") || strings.HasPrefix(cleaned, "..") {
+			return fmt.Errorf("workdir must be a relative path inside the workspace")
+		}
+	}
+	if req.TimeoutMs < 0 || time.Duration(req.TimeoutMs)*time.Millisecond > maxTimeout {
+		return fmt.Errorf("timeoutMs must be between 0 and %d", maxTimeout.Milliseconds())
+	}
+	return nil
+}
+
+func (req *Request) timeout() time.Duration {
+	if req.TimeoutMs == 0 {
+		return defaultTimeout
+	}
+	return time.Duration(req.TimeoutMs) * time.Millisecond
+}
+
+// Run executes req.Cmd in the container and streams its output to stdout
+// and stderr as it is produced. If stdin is nil, req.Stdin is sent instead
+// and stdin is closed after it. When the timeout expires or ctx is
+// cancelled, every process the command started is killed.
+func (s *Service) Run(ctx context.Context, containerID string, req Request, stdin io.Reader, stdout, stderr io.Writer) (*Result, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	if stdin == nil && req.Stdin != "" {
+		stdin = strings.NewReader(req.Stdin)
+	}
+
+	id, err := newRunID()
+	if err != nil {
+		return nil, err
+	}
+	marker := markerEnvKey + "=" + id
+
+	workDir := workspace.Root
+	if req.WorkDir != "" {
+		workDir = path.Join(workspace.Root, req.WorkDir)
+	}
+
+	execResp, err := s.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          req.Cmd,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+		Env:          append(append([]string(nil), req.Env...), marker),
+		WorkingDir:   workDir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec create: %w", err)
+	}
+
+	hijack, err := s.cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{
+		Tty: false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec attach: %w", err)
+	}
+	defer hijack.Close()
+
+	start := time.Now()
+	runCtx, cancel := context.WithTimeout(ctx, req.timeout())
+	defer cancel()
+
+	// Kill the command if it outlives the timeout or the caller goes away.
+	// Closing the stream afterwards unblocks StdCopy below.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-runCtx.Done():
+			killCtx, cancelKill := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelKill()
+			if err := docker.KillMarked(killCtx, s.cli, containerID, "", marker, "KILL"); err != nil {
+				log.Printf("[run] kill %s in %s: %v", id, containerID, err)
+			}
+			hijack.Close()
+		case <-finished:
+		}
+	}()
+
+	if stdin != nil {
+		go func() {
+			if _, err := io.Copy(hijack.Conn, stdin); err != nil {
+				log.Printf("[run] write stdin: %v", err)
+			}
+			_ = hijack.CloseWrite()
+		}()
+	}
+
+	_, copyErr := stdcopy.StdCopy(stdout, stderr, hijack.Reader)
+
+	res := &Result{DurationMs: time.Since(start).Milliseconds()}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if runCtx.Err() != nil {
+		res.TimedOut = true
+		res.ExitCode = -1
+		return res, nil
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("read exec output: %w", copyErr)
+	}
+
+	res.ExitCode, err = docker.WaitExec(ctx, s.cli, execResp.ID)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func newRunID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate run id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// limitedBuffer keeps the first max bytes written to it and records whether
+// anything was dropped.
+type limitedBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	room := b.max - len(b.buf)
+	if len(p) > room {
+		b.buf = append(b.buf, p[:max(room, 0)]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
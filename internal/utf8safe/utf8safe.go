@@ -0,0 +1,44 @@
+// Package utf8safe splits byte streams without cutting multi-byte UTF-8
+// sequences in half.
+package utf8safe
+
+import "unicode/utf8"
+
+// Replacement stands in for bytes that are not valid UTF-8.
+var Replacement = []byte(string(utf8.RuneError))
+
+// Split splits p into a prefix that does not end in the middle of a
+// multi-byte UTF-8 sequence and the incomplete trailing bytes, which should
+// be prepended to the next chunk read from the stream.
+func Split(p []byte) (complete, rest []byte) {
+	// A sequence is at most utf8.UTFMax bytes, so only the tail needs checking.
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		b := p[len(p)-i]
+		if b < utf8.RuneSelf {
+			break // ASCII: nothing pending
+		}
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return p[:len(p)-i], p[len(p)-i:]
+			}
+			break
+		}
+	}
+	return p, nil
+}
+
+// Boundary moves n back to the start of the rune it falls inside so that
+// p[:n] does not end mid-sequence. It never returns 0 for n > 0, so a frame
+// always makes progress even on invalid input.
+func Boundary(p []byte, n int) int {
+	if n >= len(p) {
+		return n
+	}
+	// p[n] is at most utf8.UTFMax-1 bytes past the start of its rune.
+	for i := n; i > 0 && n-i < utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+package utf8safe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	smile := "\xf0\x9f\x98\x80" // U+1F600, 4 bytes
+	tests := []struct {
+		name     string
+		in       string
+		complete string
+		rest     string
+	}{
+		{"empty", "", "", ""},
+		{"ascii", "abc", "abc", ""},
+		{"complete 2-byte", "aж", "aж", ""},
+		{"cut 2-byte", "a\xd0", "a", "\xd0"},
+		{"complete 3-byte", "a€", "a€", ""},
+		{"cut 3-byte after 1", "a\xe2", "a", "\xe2"},
+		{"cut 3-byte after 2", "a\xe2\x82", "a", "\xe2\x82"},
+		{"complete 4-byte", "a" + smile, "a" + smile, ""},
+		{"cut 4-byte after 1", "a\xf0", "a", "\xf0"},
+		{"cut 4-byte after 3", "a\xf0\x9f\x98", "a", "\xf0\x9f\x98"},
+		{"only partial", "\xf0\x9f", "", "\xf0\x9f"},
+		{"stray continuation", "a\x80", "a\x80", ""},
+		{"invalid then ascii", "\xff" + "a", "\xff" + "a", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complete, rest := Split([]byte(tt.in))
+			if string(complete) != tt.complete || string(rest) != tt.rest {
+				t.Errorf("Split(%q) = %q, %q; want %q, %q", tt.in, complete, rest, tt.complete, tt.rest)
+			}
+		})
+	}
+}
+
+func TestBoundary(t *testing.T) {
+	smile := "\xf0\x9f\x98\x80"
+	tests := []struct {
+		name string
+		in   string
+		n    int
+		want int
+	}{
+		{"ascii", "abcdef", 3, 3},
+		{"past end", "abc", 5, 5},
+		{"at end", "abc", 3, 3},
+		{"on rune start", "aж", 1, 1},
+		{"inside 2-byte", "aж", 2, 1},
+		{"inside 3-byte at 2nd byte", "a€b", 2, 1},
+		{"inside 3-byte at 3rd byte", "a€b", 3, 1},
+		{"inside 4-byte at 2nd byte", "a" + smile + "b", 2, 1},
+		{"inside 4-byte at 3rd byte", "a" + smile + "b", 3, 1},
+		{"inside 4-byte at 4th byte", "a" + smile + "b", 4, 1},
+		{"4th byte after ascii run", "aaaaa" + smile + "bc", 8, 5},
+		{"never zero", smile, 2, 2},
+		{"continuation run", "a\x80\x80\x80\x80\x80", 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Boundary([]byte(tt.in), tt.n); got != tt.want {
+				t.Errorf("Boundary(%q, %d) = %d, want %d", tt.in, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFraming checks that splitting a stream into small frames at rune
+// boundaries never introduces replacement characters.
+func TestFraming(t *testing.T) {
+	in := []byte("aaaaa\xf0\x9f\x98\x80bc Привіт €€")
+	for max := 4; max <= len(in); max++ {
+		var out []byte
+		for p := in; len(p) > 0; {
+			n := Boundary(p, min(len(p), max))
+			out = append(out, bytes.ToValidUTF8(p[:n], Replacement)...)
+			p = p[n:]
+		}
+		if !bytes.Equal(out, in) {
+			t.Errorf("maxFrame %d: got %q, want %q", max, out, in)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Package workspace holds what the terminal, file and run APIs agree on
+// about a container: where the learner's project lives and which
+// environment variables may be passed to the processes started in it.
+package workspace
+
+import "regexp"
+
+// Root is the folder the project is mounted at in every container.
+const Root = "/workspace"
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidEnvKey reports whether key is a portable environment variable name.
+func ValidEnvKey(key string) bool {
+	return envKeyRe.MatchString(key)
+}